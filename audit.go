@@ -0,0 +1,266 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEventType is the kind of lifecycle event emitted by the stores
+type AuditEventType string
+
+const (
+	// AuditCodeIssued an authorization code has been stored
+	AuditCodeIssued AuditEventType = "CodeIssued"
+	// AuditCodeConsumed an authorization code has been removed
+	AuditCodeConsumed AuditEventType = "CodeConsumed"
+	// AuditTokenIssued an access token(and its refresh token if any) has been stored
+	AuditTokenIssued AuditEventType = "TokenIssued"
+	// AuditTokenRefreshed an access token has been issued by the refresh token grant,
+	// the tokens it replaces are recorded as TokenRevoked when the rotation removes them
+	AuditTokenRefreshed AuditEventType = "TokenRefreshed"
+	// AuditTokenRevoked an access or a refresh token has been removed
+	AuditTokenRevoked AuditEventType = "TokenRevoked"
+	// AuditClientCreated a client has been stored
+	AuditClientCreated AuditEventType = "ClientCreated"
+	// AuditClientSecretRotated the secret of a client has been replaced
+	AuditClientSecretRotated AuditEventType = "ClientSecretRotated"
+	// AuditClientDeleted a client has been removed
+	AuditClientDeleted AuditEventType = "ClientDeleted"
+)
+
+// namespaceExistsCode is returned by create when the collection already exists
+const namespaceExistsCode = 48
+
+// AuditEvent is a lifecycle event, it never holds token values or secrets
+type AuditEvent struct {
+	ID         string         `bson:"_id" json:"id"`
+	Type       AuditEventType `bson:"Type" json:"type"`
+	UserID     string         `bson:"UserID,omitempty" json:"user_id,omitempty"`
+	ClientID   string         `bson:"ClientID,omitempty" json:"client_id,omitempty"`
+	Scope      string         `bson:"Scope,omitempty" json:"scope,omitempty"`
+	TokenType  string         `bson:"TokenType,omitempty" json:"token_type,omitempty"`
+	Service    string         `bson:"Service,omitempty" json:"service,omitempty"`
+	Tenant     string         `bson:"Tenant,omitempty" json:"tenant,omitempty"`
+	OccurredAt time.Time      `bson:"OccurredAt" json:"occurred_at"`
+}
+
+// AuditSink receive the lifecycle events of the stores
+type AuditSink interface {
+	Emit(ctx context.Context, event *AuditEvent) error
+}
+
+// SetAuditSink set the sink receiving the lifecycle events of the stores
+func (sc *StoreConfig) SetAuditSink(sink AuditSink) *StoreConfig {
+	sc.auditSink = sink
+	return sc
+}
+
+// audit emit the event to the sink if any, a failing sink never fails the store operation
+func (sc *StoreConfig) audit(ctx context.Context, event *AuditEvent) {
+	if sc.auditSink == nil {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	event.ID = primitive.NewObjectID().Hex()
+	event.Service = sc.service
+	event.Tenant = TenantFromContext(ctx)
+	event.OccurredAt = time.Now().UTC()
+
+	if err := sc.auditSink.Emit(ctx, event); err != nil {
+		sc.log().Error("Err audit emit", "event", string(event.Type), "error", err)
+	}
+}
+
+// tokenAuditEvent build an event from the token information, without the token values
+func tokenAuditEvent(typ AuditEventType, tokenType string, ti oauth2.TokenInfo) *AuditEvent {
+	event := &AuditEvent{
+		Type:      typ,
+		TokenType: tokenType,
+	}
+	if ti != nil {
+		event.UserID = ti.GetUserID()
+		event.ClientID = ti.GetClientID()
+		event.Scope = ti.GetScope()
+	}
+	return event
+}
+
+// issuedEventType tell a token issued by the refresh token grant, whose refresh token was created before
+// the access token, from a new grant. With RefreshingConfig.IsResetRefreshTime both are created together
+// and the refresh is recorded as TokenIssued
+func issuedEventType(info oauth2.TokenInfo) AuditEventType {
+	if info.GetRefresh() != "" && info.GetRefreshCreateAt().Before(info.GetAccessCreateAt()) {
+		return AuditTokenRefreshed
+	}
+	return AuditTokenIssued
+}
+
+// auditTokenInfo lookup the token information before its removal, only when an audit sink is set
+func (ts *TokenStore) auditTokenInfo(ctx context.Context, cname, token string) oauth2.TokenInfo {
+	if ts.tcfg.storeConfig.auditSink == nil {
+		return nil
+	}
+
+	basicID := token
+	if cname != ts.tcfg.BasicCName {
+		id, err := ts.getBasicID(ctx, cname, token)
+		if err != nil {
+			return nil
+		}
+		basicID = id
+	}
+
+	ti, _ := ts.getData(ctx, basicID)
+	return ti
+}
+
+// AuditConfig audit collection configuration parameters
+type AuditConfig struct {
+	// store audit events collection name(The default is oauth2_audit)
+	AuditCName string
+	// when set, the collection is created capped to this size in bytes
+	CappedSize int64
+	// when set and the collection is not capped, events are removed after this duration
+	Retention time.Duration
+}
+
+// NewDefaultAuditConfig create a default audit configuration, events are kept forever
+func NewDefaultAuditConfig() *AuditConfig {
+	return &AuditConfig{
+		AuditCName: "oauth2_audit",
+	}
+}
+
+// AuditQuery filter the events returned by AuditStore.Find, zero values are ignored
+type AuditQuery struct {
+	UserID   string
+	ClientID string
+	Type     AuditEventType
+	From     time.Time
+	To       time.Time
+	// maximum number of events returned(The default is 100)
+	Limit int64
+}
+
+// AuditStore is an AuditSink appending the events to a MongoDB collection
+type AuditStore struct {
+	acfg   *AuditConfig
+	scfg   *StoreConfig
+	client *mongo.Client
+}
+
+// NewAuditStoreWithSession create an audit store instance based on mongodb
+func NewAuditStoreWithSession(client *mongo.Client, cfg *Config, acfg *AuditConfig, scfgs ...*StoreConfig) *AuditStore {
+	if acfg == nil {
+		acfg = NewDefaultAuditConfig()
+	}
+
+	as := &AuditStore{
+		acfg:   acfg,
+		scfg:   NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet),
+		client: client,
+	}
+	as.scfg.override(scfgs...)
+
+	ctx := context.Background()
+	db := client.Database(as.scfg.db)
+
+	if acfg.CappedSize > 0 {
+		err := db.CreateCollection(ctx, acfg.AuditCName, options.CreateCollection().SetCapped(true).SetSizeInBytes(acfg.CappedSize))
+		var cmdErr mongo.CommandError
+		if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == namespaceExistsCode) {
+			as.scfg.log().Error("Error creating capped collection", "collection", acfg.AuditCName, "error", err)
+			panic(err)
+		}
+	}
+
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "UserID", Value: 1}, {Key: "OccurredAt", Value: -1}}},
+		{Keys: bson.D{{Key: "ClientID", Value: 1}, {Key: "OccurredAt", Value: -1}}},
+	}
+	if acfg.CappedSize <= 0 && acfg.Retention > 0 {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys:    bson.D{{Key: "OccurredAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(acfg.Retention / time.Second)),
+		})
+	}
+
+	if _, err := as.c().Indexes().CreateMany(ctx, indexes); err != nil {
+		as.scfg.log().Error("Error creating index", "collection", acfg.AuditCName, "error", err)
+		panic(err)
+	}
+
+	return as
+}
+
+func (as *AuditStore) c() *mongo.Collection {
+	return as.client.Database(as.scfg.db).Collection(as.acfg.AuditCName, as.scfg.writeOptions(""))
+}
+
+// Emit append the event to the audit collection
+func (as *AuditStore) Emit(ctx context.Context, event *AuditEvent) error {
+	ctxReq, cancel := as.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = ctxReq
+	}
+
+	_, err := as.c().InsertOne(ctx, event)
+	return err
+}
+
+// Find return the events matching the query, the most recent first
+func (as *AuditStore) Find(ctx context.Context, q AuditQuery) ([]*AuditEvent, error) {
+	ctxReq, cancel := as.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = ctxReq
+	}
+
+	filter := bson.M{}
+	if q.UserID != "" {
+		filter["UserID"] = q.UserID
+	}
+	if q.ClientID != "" {
+		filter["ClientID"] = q.ClientID
+	}
+	if q.Type != "" {
+		filter["Type"] = q.Type
+	}
+	occurred := bson.M{}
+	if !q.From.IsZero() {
+		occurred["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		occurred["$lt"] = q.To
+	}
+	if len(occurred) > 0 {
+		filter["OccurredAt"] = occurred
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	cursor, err := as.c().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "OccurredAt", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
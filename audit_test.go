@@ -0,0 +1,110 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockAuditSink record the emitted events
+type mockAuditSink struct {
+	events []*AuditEvent
+	err    error
+}
+
+func (ms *mockAuditSink) Emit(ctx context.Context, event *AuditEvent) error {
+	ms.events = append(ms.events, event)
+	return ms.err
+}
+
+func TestAuditEmit(t *testing.T) {
+	Convey("Test audit emit", t, func() {
+		sink := &mockAuditSink{}
+		sc := NewDefaultStoreConfig(dbName, service, false).SetAuditSink(sink)
+
+		info := &models.Token{
+			ClientID: "client",
+			UserID:   "user",
+			Scope:    "all",
+			Access:   "secret_access",
+		}
+
+		Convey("TokenIssued", func() {
+			sc.audit(context.TODO(), tokenAuditEvent(AuditTokenIssued, "access", info))
+
+			So(len(sink.events), ShouldEqual, 1)
+			event := sink.events[0]
+			So(event.ID, ShouldNotBeEmpty)
+			So(event.Type, ShouldEqual, AuditTokenIssued)
+			So(event.UserID, ShouldEqual, "user")
+			So(event.ClientID, ShouldEqual, "client")
+			So(event.Service, ShouldEqual, service)
+			So(event.OccurredAt.IsZero(), ShouldBeFalse)
+		})
+
+		Convey("TokenRefreshed", func() {
+			now := time.Now()
+			issued := &models.Token{Access: "a", AccessCreateAt: now, Refresh: "r", RefreshCreateAt: now}
+			So(issuedEventType(issued), ShouldEqual, AuditTokenIssued)
+
+			refreshed := &models.Token{Access: "a", AccessCreateAt: now, Refresh: "r", RefreshCreateAt: now.Add(-time.Hour)}
+			So(issuedEventType(refreshed), ShouldEqual, AuditTokenRefreshed)
+
+			So(issuedEventType(&models.Token{Access: "a", AccessCreateAt: now}), ShouldEqual, AuditTokenIssued)
+		})
+
+		Convey("FailingSink", func() {
+			sink.err = errors.New("sink down")
+
+			sc.audit(context.TODO(), tokenAuditEvent(AuditTokenRevoked, "refresh", nil))
+
+			So(len(sink.events), ShouldEqual, 1)
+			So(sink.events[0].UserID, ShouldBeEmpty)
+		})
+
+		Convey("NoSink", func() {
+			NewStoreConfig(1, 1).audit(context.TODO(), tokenAuditEvent(AuditTokenIssued, "access", info))
+		})
+	})
+}
+
+func TestAuditStore(t *testing.T) {
+	Convey("Test mongodb audit store", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		tokenStore := NewTokenStore(cfg)
+		acfg := NewDefaultAuditConfig()
+		acfg.Retention = time.Hour
+		auditStore := NewAuditStoreWithSession(tokenStore.client, cfg, acfg)
+
+		store := NewTokenStoreWithSession(tokenStore.client, cfg, NewStoreConfig(0, 0).SetAuditSink(auditStore))
+
+		from := time.Now().Add(-time.Second)
+		info := &models.Token{
+			ClientID:        "audit_client",
+			UserID:          "audit_user",
+			Scope:           "all",
+			Access:          "audit_access",
+			AccessCreateAt:  time.Now(),
+			AccessExpiresIn: time.Second * 5,
+		}
+		So(store.Create(context.TODO(), info), ShouldBeNil)
+		So(store.RemoveByAccess(context.TODO(), info.Access), ShouldBeNil)
+
+		events, err := auditStore.Find(context.TODO(), AuditQuery{UserID: "audit_user", From: from})
+		So(err, ShouldBeNil)
+		So(len(events), ShouldEqual, 2)
+		So(events[0].Type, ShouldEqual, AuditTokenRevoked)
+		So(events[1].Type, ShouldEqual, AuditTokenIssued)
+		So(events[1].ClientID, ShouldEqual, "audit_client")
+	})
+}
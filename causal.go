@@ -0,0 +1,95 @@
+package mongo
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type causalKey struct{}
+
+// causalSessionKey mark the session started by causal, the only one the TokenStore transactions may join
+type causalSessionKey struct{}
+
+// causalState hold the cluster and operation times reached by the operations sharing a context
+type causalState struct {
+	mu            sync.Mutex
+	clusterTime   bson.Raw
+	operationTime *primitive.Timestamp
+}
+
+// advance move the session to the times reached by the previous operations
+func (cs *causalState) advance(sess mongo.Session) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.clusterTime != nil {
+		if err := sess.AdvanceClusterTime(cs.clusterTime); err != nil {
+			return err
+		}
+	}
+	if cs.operationTime != nil {
+		return sess.AdvanceOperationTime(cs.operationTime)
+	}
+	return nil
+}
+
+// save keep the times reached by the session, for the next operations
+func (cs *causalState) save(sess mongo.Session) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if ct := sess.ClusterTime(); ct != nil {
+		cs.clusterTime = ct
+	}
+	if ot := sess.OperationTime(); ot != nil {
+		cs.operationTime = ot
+	}
+}
+
+// SetCausalConsistency run the TokenStore operations of a context made by WithCausalSession
+// in causally consistent sessions, each one observing the writes of the previous ones,
+// even on a secondary read preference
+func (sc *StoreConfig) SetCausalConsistency(enabled bool) *StoreConfig {
+	sc.causalConsistency = enabled
+	return sc
+}
+
+// WithCausalSession return a context binding the TokenStore operations made with it, e.g. the token
+// creation and the lookups of a request, once SetCausalConsistency is enabled
+func WithCausalSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, causalKey{}, &causalState{})
+}
+
+// causal run h in a causally consistent session advanced to the times of the context,
+// h runs as is without WithCausalSession or within a session already
+func (ts *TokenStore) causal(ctx context.Context, h func(ctx context.Context) error) error {
+	cs, _ := ctx.Value(causalKey{}).(*causalState)
+	if !ts.tcfg.storeConfig.causalConsistency || cs == nil || mongo.SessionFromContext(ctx) != nil {
+		return h(ctx)
+	}
+
+	sess, err := ts.client.StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+
+	if err = cs.advance(sess); err != nil {
+		return err
+	}
+	err = h(context.WithValue(mongo.NewSessionContext(ctx, sess), causalSessionKey{}, sess))
+	cs.save(sess)
+	return err
+}
+
+// causalSession return the session of the context when causal started it, nil for a session of the caller
+func causalSession(ctx context.Context) mongo.Session {
+	sess, _ := ctx.Value(causalSessionKey{}).(mongo.Session)
+	if sess == nil || sess != mongo.SessionFromContext(ctx) {
+		return nil
+	}
+	return sess
+}
@@ -0,0 +1,87 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCausalSession(t *testing.T) {
+	Convey("Test causally consistent sessions", t, func() {
+		// the sessions are client side, no server is needed
+		client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+		So(err, ShouldBeNil)
+		defer client.Disconnect(context.TODO())
+
+		ts := &TokenStore{
+			tcfg:   NewDefaultTokenConfig(NewDefaultStoreConfig(dbName, service, true)),
+			client: client,
+		}
+
+		Convey("Test without WithCausalSession", func() {
+			ts.tcfg.storeConfig.SetCausalConsistency(true)
+			err := ts.causal(context.TODO(), func(ctx context.Context) error {
+				So(mongo.SessionFromContext(ctx), ShouldBeNil)
+				return nil
+			})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Test disabled", func() {
+			err := ts.causal(WithCausalSession(context.TODO()), func(ctx context.Context) error {
+				So(mongo.SessionFromContext(ctx), ShouldBeNil)
+				return nil
+			})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Test the times are carried to the next operations", func() {
+			ts.tcfg.storeConfig.SetCausalConsistency(true)
+			ctx := WithCausalSession(context.TODO())
+			opTime := &primitive.Timestamp{T: 100, I: 1}
+
+			err := ts.causal(ctx, func(ctx context.Context) error {
+				sess := mongo.SessionFromContext(ctx)
+				So(sess, ShouldNotBeNil)
+				So(sess.OperationTime(), ShouldBeNil)
+
+				// the session is kept by the request contexts
+				ctxReq, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				So(mongo.SessionFromContext(withSpan(ctxReq, ctx)), ShouldEqual, sess)
+				So(causalSession(withSpan(ctxReq, ctx)), ShouldEqual, sess)
+
+				return sess.AdvanceOperationTime(opTime)
+			})
+			So(err, ShouldBeNil)
+
+			err = ts.causal(ctx, func(ctx context.Context) error {
+				So(mongo.SessionFromContext(ctx).OperationTime(), ShouldResemble, opTime)
+				return nil
+			})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Test a session of the caller is not joined", func() {
+			ts.tcfg.storeConfig.SetCausalConsistency(true)
+			sess, err := client.StartSession()
+			So(err, ShouldBeNil)
+			defer sess.EndSession(context.TODO())
+
+			ctx := mongo.NewSessionContext(WithCausalSession(context.TODO()), sess)
+			So(causalSession(ctx), ShouldBeNil)
+
+			err = ts.causal(ctx, func(ctx context.Context) error {
+				So(mongo.SessionFromContext(ctx), ShouldEqual, sess)
+				So(causalSession(ctx), ShouldBeNil)
+				return nil
+			})
+			So(err, ShouldBeNil)
+		})
+	})
+}
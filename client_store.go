@@ -3,7 +3,6 @@ package mongo
 import (
 	"context"
 	"errors"
-	"log"
 	"time"
 
 	"github.com/go-oauth2/oauth2/v4"
@@ -11,6 +10,10 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // TODO
@@ -26,6 +29,24 @@ type StoreConfig struct {
 	connectionTimeout int
 	requestTimeout    int
 	isReplicaSet      bool
+	isSharded         bool
+	txnBacklogLimit   int64
+	tracerProvider    trace.TracerProvider
+	metrics           *Metrics
+	logger            Logger
+	auditSink         AuditSink
+	tokenMiddlewares  []TokenMiddleware
+	revocationPolling time.Duration
+	watchConsumer     string
+	codeSingleUse     bool
+	multiTenant       bool
+	kekProvider       KEKProvider
+	readPrefs         map[ReadOperation]*readpref.ReadPref
+	readConcerns      map[ReadOperation]*readconcern.ReadConcern
+	primaryFallback   bool
+	writeConcerns     map[WriteOperation]*writeconcern.WriteConcern
+	causalConsistency bool
+	autoMigrate       *MigrationConfig
 }
 
 func NewStoreConfig(ctout, rtout int) *StoreConfig {
@@ -45,11 +66,82 @@ func NewDefaultStoreConfig(db, service string, isReplicasSet bool) *StoreConfig
 	}
 }
 
+// override replace the default configs with the ones set in scfgs
+func (sc *StoreConfig) override(scfgs ...*StoreConfig) {
+	if len(scfgs) == 0 {
+		return
+	}
+	if scfgs[0].connectionTimeout > 0 {
+		sc.connectionTimeout = scfgs[0].connectionTimeout
+	}
+	if scfgs[0].requestTimeout > 0 {
+		sc.requestTimeout = scfgs[0].requestTimeout
+	}
+	if scfgs[0].txnBacklogLimit > 0 {
+		sc.txnBacklogLimit = scfgs[0].txnBacklogLimit
+	}
+	if scfgs[0].tracerProvider != nil {
+		sc.tracerProvider = scfgs[0].tracerProvider
+	}
+	if scfgs[0].metrics != nil {
+		sc.metrics = scfgs[0].metrics
+	}
+	if scfgs[0].logger != nil {
+		sc.logger = scfgs[0].logger
+	}
+	if scfgs[0].auditSink != nil {
+		sc.auditSink = scfgs[0].auditSink
+	}
+	if len(scfgs[0].tokenMiddlewares) > 0 {
+		sc.tokenMiddlewares = scfgs[0].tokenMiddlewares
+	}
+	if scfgs[0].revocationPolling > 0 {
+		sc.revocationPolling = scfgs[0].revocationPolling
+	}
+	if scfgs[0].watchConsumer != "" {
+		sc.watchConsumer = scfgs[0].watchConsumer
+	}
+	if scfgs[0].codeSingleUse {
+		sc.codeSingleUse = true
+	}
+	if scfgs[0].multiTenant {
+		sc.multiTenant = true
+	}
+	if scfgs[0].kekProvider != nil {
+		sc.kekProvider = scfgs[0].kekProvider
+	}
+	if len(scfgs[0].readPrefs) > 0 {
+		sc.readPrefs = scfgs[0].readPrefs
+	}
+	if len(scfgs[0].readConcerns) > 0 {
+		sc.readConcerns = scfgs[0].readConcerns
+	}
+	if scfgs[0].primaryFallback {
+		sc.primaryFallback = true
+	}
+	if len(scfgs[0].writeConcerns) > 0 {
+		sc.writeConcerns = scfgs[0].writeConcerns
+	}
+	if scfgs[0].causalConsistency {
+		sc.causalConsistency = true
+	}
+	if scfgs[0].autoMigrate != nil {
+		sc.autoMigrate = scfgs[0].autoMigrate
+	}
+}
+
+// SetTxnBacklogLimit set the number of pending transaction entries
+// above which TokenStore.Health reports the store as down(The default is 1000)
+func (sc *StoreConfig) SetTxnBacklogLimit(limit int64) *StoreConfig {
+	sc.txnBacklogLimit = limit
+	return sc
+}
+
 // setRequestContext set a WithTimeout or Background context
 func (sc *StoreConfig) setRequestContext() (context.Context, context.CancelFunc) {
 	ctx := context.Background()
 	if sc.requestTimeout > 0 {
-		log.Println("Request timeout: ", sc.requestTimeout)
+		sc.log().Debug("Request timeout", "timeout", sc.requestTimeout)
 		timeout := time.Duration(sc.requestTimeout) * time.Second
 		return context.WithTimeout(ctx, timeout)
 	}
@@ -83,6 +175,7 @@ func NewDefaultClientConfig(strCfgs *StoreConfig) *ClientConfig {
 }
 
 // NewClientStore create a client store instance based on mongodb
+// it panics if mongo can not be reached
 func NewClientStore(cfg *Config, scfgs ...*StoreConfig) *ClientStore {
 	clientOptions := options.Client().ApplyURI(cfg.URL)
 	ctx := context.TODO()
@@ -102,26 +195,29 @@ func NewClientStore(cfg *Config, scfgs ...*StoreConfig) *ClientStore {
 		clientOptions.SetConnectTimeout(time.Duration(scfgs[0].requestTimeout) * time.Second)
 	}
 
-	if !cfg.IsReplicaSet {
+	if !cfg.IsReplicaSet || cfg.IsSharded {
 		clientOptions.SetAuth(options.Credential{
 			Username: cfg.Username,
 			Password: cfg.Password,
 		})
 	}
 
+	logger := storeLogger(scfgs...)
+
 	c, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.Fatal("ClientStore failed to connect mongo: ", err)
-	} else {
-		log.Println("Connection to mongoDB successful")
+		logger.Error("ClientStore failed to connect mongo", "error", err)
+		panic(err)
 	}
+	logger.Info("Connection to mongoDB successful")
 
 	err = c.Ping(ctxPing, nil)
 	if err != nil {
-		log.Fatal("MongoDB ping failed:", err)
+		logger.Error("MongoDB ping failed", "error", err)
+		panic(err)
 	}
 
-	log.Println("Ping db successfull")
+	logger.Debug("Ping db successful")
 
 	return NewClientStoreWithSession(c, cfg, scfgs...)
 }
@@ -129,18 +225,20 @@ func NewClientStore(cfg *Config, scfgs ...*StoreConfig) *ClientStore {
 // NewClientStoreWithSession create a client store instance based on mongodb
 func NewClientStoreWithSession(client *mongo.Client, cfg *Config, scfgs ...*StoreConfig) *ClientStore {
 	strCfgs := NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet)
+	strCfgs.isSharded = cfg.IsSharded
 
 	cs := &ClientStore{
 		client: client,
 		ccfg:   NewDefaultClientConfig(strCfgs),
 	}
 
-	if len(scfgs) > 0 {
-		if scfgs[0].connectionTimeout > 0 {
-			cs.ccfg.storeConfig.connectionTimeout = scfgs[0].connectionTimeout
-		}
-		if scfgs[0].requestTimeout > 0 {
-			cs.ccfg.storeConfig.requestTimeout = scfgs[0].requestTimeout
+	cs.ccfg.storeConfig.override(scfgs...)
+
+	// a multi-tenant store migrates each tenant database on the first call of its TokenStore
+	if cs.ccfg.storeConfig.autoMigrate != nil && !cs.ccfg.storeConfig.multiTenant {
+		if err := autoMigrate(context.TODO(), client, cs.ccfg.storeConfig, nil); err != nil {
+			cs.ccfg.storeConfig.log().Error("Error migrating", "error", err)
+			panic(err)
 		}
 	}
 
@@ -156,22 +254,32 @@ type ClientStore struct {
 // Close close the mongo session
 func (cs *ClientStore) Close() {
 	if err := cs.client.Disconnect(context.Background()); err != nil {
-		log.Fatal(err)
+		cs.ccfg.storeConfig.log().Error("ClientStore failed to disconnect mongo", "error", err)
 	}
 }
 
-func (cs *ClientStore) c(name string) *mongo.Collection {
-	return cs.client.Database(cs.ccfg.storeConfig.db).Collection(name)
+func (cs *ClientStore) c(ctx context.Context, name string, opts ...*options.CollectionOptions) *mongo.Collection {
+	return cs.client.Database(cs.ccfg.storeConfig.dbName(ctx)).Collection(name, opts...)
 }
 
 // Create create client information
 func (cs *ClientStore) Create(info oauth2.ClientInfo) (err error) {
-	ctx := context.Background()
+	return cs.CreateWithContext(context.Background(), info)
+}
+
+// CreateWithContext create client information, in the tenant of ctx for a multi-tenant store
+func (cs *ClientStore) CreateWithContext(ctx context.Context, info oauth2.ClientInfo) (err error) {
+	ctx, op := cs.ccfg.storeConfig.startOperation(ctx, "ClientStore.Create", "insert", cs.ccfg.ClientsCName)
+	defer func() { op.end(err) }()
+
+	if err = cs.ccfg.storeConfig.checkTenant(ctx); err != nil {
+		return
+	}
 
 	ctxReq, cancel := cs.ccfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
 	}
 
 	entity := &client{
@@ -180,31 +288,55 @@ func (cs *ClientStore) Create(info oauth2.ClientInfo) (err error) {
 		Domain: info.GetDomain(),
 		UserID: info.GetUserID(),
 	}
+	if cs.ccfg.storeConfig.kekProvider != nil {
+		entity.SealedSecret, err = cs.ccfg.storeConfig.seal(ctx, []byte(entity.Secret), fieldAAD(entity.ID, "sealedSecret"))
+		if err != nil {
+			return
+		}
+		entity.Secret = ""
+	}
 
-	collection := cs.c(cs.ccfg.ClientsCName)
+	collection := cs.c(ctx, cs.ccfg.ClientsCName, cs.ccfg.storeConfig.writeOptions(WriteClient))
 
 	_, err = collection.InsertOne(ctx, entity)
 	if err != nil {
 		if !mongo.IsDuplicateKeyError(err) {
-			log.Fatal(err)
+			cs.ccfg.storeConfig.log().Error("Error ClientStore.Create", "operation", "insert", "collection", cs.ccfg.ClientsCName, "error", err)
 		} else {
 			return nil
 		}
+		return
 	}
 
+	cs.ccfg.storeConfig.audit(ctx, &AuditEvent{
+		Type:     AuditClientCreated,
+		ClientID: entity.ID,
+		UserID:   entity.UserID,
+	})
 	return
 }
 
 // GetByID according to the ID for the client information
 func (cs *ClientStore) GetByID(ctx context.Context, id string) (info oauth2.ClientInfo, err error) {
+	ctx, op := cs.ccfg.storeConfig.startOperation(ctx, "ClientStore.GetByID", "find", cs.ccfg.ClientsCName)
+	defer func() { op.endLookup(info != nil, err) }()
+
+	if err = cs.ccfg.storeConfig.checkTenant(ctx); err != nil {
+		return
+	}
+
 	ctxReq, cancel := cs.ccfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
 	}
 
+	sc := cs.ccfg.storeConfig
 	filter := bson.M{"_id": id}
-	result := cs.c(cs.ccfg.ClientsCName).FindOne(ctx, filter)
+	result := cs.c(ctx, cs.ccfg.ClientsCName, sc.readOptions(ReadClientGetByID, false)).FindOne(ctx, filter)
+	if result.Err() == mongo.ErrNoDocuments && sc.fallsBack(ReadClientGetByID) {
+		result = cs.c(ctx, cs.ccfg.ClientsCName, sc.readOptions(ReadClientGetByID, true)).FindOne(ctx, filter)
+	}
 	if err := result.Err(); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, err
@@ -215,12 +347,17 @@ func (cs *ClientStore) GetByID(ctx context.Context, id string) (info oauth2.Clie
 
 	entity := &client{}
 	if err := result.Decode(entity); err != nil {
-		log.Println(err)
+		cs.ccfg.storeConfig.log().Error("Error ClientStore.GetByID decode", "operation", "find", "collection", cs.ccfg.ClientsCName, "error", err)
+	}
+
+	secret, err := cs.openSecret(ctx, entity)
+	if err != nil {
+		return nil, err
 	}
 
 	info = &models.Client{
 		ID:     entity.ID,
-		Secret: entity.Secret,
+		Secret: secret,
 		Domain: entity.Domain,
 		UserID: entity.UserID,
 	}
@@ -228,19 +365,171 @@ func (cs *ClientStore) GetByID(ctx context.Context, id string) (info oauth2.Clie
 	return
 }
 
+// Update replace the domain and the user id of the client, see RotateSecret for its secret
+func (cs *ClientStore) Update(ctx context.Context, info oauth2.ClientInfo) (err error) {
+	ctx, op := cs.ccfg.storeConfig.startOperation(ctx, "ClientStore.Update", "update", cs.ccfg.ClientsCName)
+	defer func() { op.end(err) }()
+
+	if err = cs.ccfg.storeConfig.checkTenant(ctx); err != nil {
+		return
+	}
+
+	ctxReq, cancel := cs.ccfg.storeConfig.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	res, err := cs.c(ctx, cs.ccfg.ClientsCName, cs.ccfg.storeConfig.writeOptions(WriteClient)).UpdateOne(ctx,
+		bson.M{"_id": info.GetID()},
+		bson.M{"$set": bson.M{"domain": info.GetDomain(), "userid": info.GetUserID()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// List return the clients ordered by id, after the afterID one, up to limit(all of them when 0)
+func (cs *ClientStore) List(ctx context.Context, afterID string, limit int64) (infos []oauth2.ClientInfo, err error) {
+	ctx, op := cs.ccfg.storeConfig.startOperation(ctx, "ClientStore.List", "find", cs.ccfg.ClientsCName)
+	defer func() { op.end(err) }()
+
+	if err = cs.ccfg.storeConfig.checkTenant(ctx); err != nil {
+		return
+	}
+
+	ctxReq, cancel := cs.ccfg.storeConfig.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	filter := bson.M{}
+	if afterID != "" {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+	cursor, err := cs.c(ctx, cs.ccfg.ClientsCName).Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	var entities []client
+	if err = cursor.All(ctx, &entities); err != nil {
+		return nil, err
+	}
+
+	for i := range entities {
+		secret, err := cs.openSecret(ctx, &entities[i])
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, &models.Client{
+			ID:     entities[i].ID,
+			Secret: secret,
+			Domain: entities[i].Domain,
+			UserID: entities[i].UserID,
+		})
+	}
+	return infos, nil
+}
+
 // RemoveByID use the client id to delete the client information
 func (cs *ClientStore) RemoveByID(id string) (err error) {
-	ctx := context.Background()
+	return cs.RemoveByIDWithContext(context.Background(), id)
+}
+
+// RemoveByIDWithContext use the client id to delete the client information, in the tenant of ctx for a multi-tenant store
+func (cs *ClientStore) RemoveByIDWithContext(ctx context.Context, id string) (err error) {
+	ctx, op := cs.ccfg.storeConfig.startOperation(ctx, "ClientStore.RemoveByID", "delete", cs.ccfg.ClientsCName)
+	defer func() { op.end(err) }()
+
+	if err = cs.ccfg.storeConfig.checkTenant(ctx); err != nil {
+		return
+	}
 
 	ctxReq, cancel := cs.ccfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
 	}
 
 	filter := bson.M{"_id": id}
-	_, err = cs.c(cs.ccfg.ClientsCName).DeleteOne(ctx, filter)
-	return err
+	res, err := cs.c(ctx, cs.ccfg.ClientsCName, cs.ccfg.storeConfig.writeOptions(WriteClient)).DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount > 0 {
+		cs.ccfg.storeConfig.audit(ctx, &AuditEvent{
+			Type:     AuditClientDeleted,
+			ClientID: id,
+		})
+	}
+	return nil
+}
+
+// RotateSecret replace the secret of the client
+func (cs *ClientStore) RotateSecret(ctx context.Context, id, secret string) (err error) {
+	ctx, op := cs.ccfg.storeConfig.startOperation(ctx, "ClientStore.RotateSecret", "update", cs.ccfg.ClientsCName)
+	defer func() { op.end(err) }()
+
+	if err = cs.ccfg.storeConfig.checkTenant(ctx); err != nil {
+		return
+	}
+
+	ctxReq, cancel := cs.ccfg.storeConfig.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	update, err := cs.sealSecret(ctx, id, secret)
+	if err != nil {
+		return err
+	}
+
+	res, err := cs.c(ctx, cs.ccfg.ClientsCName, cs.ccfg.storeConfig.writeOptions(WriteClient)).UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	cs.ccfg.storeConfig.audit(ctx, &AuditEvent{
+		Type:     AuditClientSecretRotated,
+		ClientID: id,
+	})
+	return nil
+}
+
+// Health verify the connectivity, the replicaSet primary and the clients collection index
+func (cs *ClientStore) Health(ctx context.Context) *HealthStatus {
+	hs := &HealthStatus{Status: HealthStatusUp}
+
+	err := checkConnectivity(ctx, cs.client)
+	hs.add("mongo.ping", err, nil)
+	if err != nil {
+		return hs
+	}
+
+	if cs.ccfg.storeConfig.isReplicaSet {
+		primary, err := checkPrimary(ctx, cs.client, cs.ccfg.storeConfig.db)
+		hs.add("mongo.primary", err, primary)
+	}
+
+	// a multi-tenant store checks the database of the tenant of ctx, if any
+	if cs.ccfg.storeConfig.checkTenant(ctx) != nil {
+		return hs
+	}
+
+	err = checkIndex(ctx, cs.c(ctx, cs.ccfg.ClientsCName), "_id", false, true)
+	hs.add("index."+cs.ccfg.ClientsCName, err, nil)
+
+	return hs
 }
 
 type client struct {
@@ -248,4 +537,6 @@ type client struct {
 	Secret string `bson:"secret"`
 	Domain string `bson:"domain"`
 	UserID string `bson:"userid"`
+	// the secret encrypted at rest, see SetFieldEncryption
+	SealedSecret *sealedField `bson:"sealedSecret,omitempty"`
 }
@@ -131,6 +131,12 @@ func TestClientStore(t *testing.T) {
 		})
 	})
 
+	Convey("Health", t, func() {
+		hs := store.Health(context.TODO())
+
+		So(hs.IsUp(), ShouldBeTrue)
+	})
+
 	Convey("RemoveByID", t, func() {
 		Convey("UnknownClient", func() {
 
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// clientView is the output of a client, the secret is hidden unless asked for
+type clientView struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret,omitempty"`
+	Domain string `json:"domain"`
+	UserID string `json:"user_id"`
+}
+
+func newClientView(info oauth2.ClientInfo, showSecret bool) *clientView {
+	v := &clientView{ID: info.GetID(), Domain: info.GetDomain(), UserID: info.GetUserID()}
+	if showSecret {
+		v.Secret = info.GetSecret()
+	}
+	return v
+}
+
+// newSecret generate a random client secret
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (c *cli) clientCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("client "+args[0], flag.ContinueOnError)
+	id := fs.String("id", "", "client id, generated when empty")
+	secret := fs.String("secret", "", "client secret, generated when empty")
+	domain := fs.String("domain", "", "client domain, the redirect URI")
+	user := fs.String("user", "", "user id owning the client")
+	showSecret := fs.Bool("show-secret", false, "print the client secret")
+	after := fs.String("after", "", "list the clients after this id")
+	limit := fs.Int64("limit", 0, "maximum number of clients listed, all when 0")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+	switch args[0] {
+	case "create", "show", "list", "update", "delete", "rotate-secret":
+	default:
+		return errUsage
+	}
+
+	cs, err := c.clientStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		if fs.NArg() != 0 {
+			return errUsage
+		}
+		if *id == "" {
+			*id = primitive.NewObjectID().Hex()
+		}
+		if *secret == "" {
+			if *secret, err = newSecret(); err != nil {
+				return err
+			}
+		}
+		info := &models.Client{ID: *id, Secret: *secret, Domain: *domain, UserID: *user}
+		if err = cs.CreateWithContext(ctx, info); err != nil {
+			return err
+		}
+		return c.printClient(newClientView(info, true))
+
+	case "show":
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		info, err := cs.GetByID(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return c.printClient(newClientView(info, *showSecret))
+
+	case "list":
+		if fs.NArg() != 0 {
+			return errUsage
+		}
+		infos, err := cs.List(ctx, *after, *limit)
+		if err != nil {
+			return err
+		}
+		views := make([]*clientView, 0, len(infos))
+		for _, info := range infos {
+			views = append(views, newClientView(info, *showSecret))
+		}
+		return c.printClients(views)
+
+	case "update":
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		info, err := cs.GetByID(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		updated := &models.Client{ID: info.GetID(), Domain: info.GetDomain(), UserID: info.GetUserID()}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "domain":
+				updated.Domain = *domain
+			case "user":
+				updated.UserID = *user
+			}
+		})
+		if err = cs.Update(ctx, updated); err != nil {
+			return err
+		}
+		return c.printClient(newClientView(updated, false))
+
+	case "delete":
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		return cs.RemoveByIDWithContext(ctx, fs.Arg(0))
+
+	case "rotate-secret":
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		// a sealed secret is read first, it is not replaced by a clear one without the key-encryption keys
+		if _, err = cs.GetByID(ctx, fs.Arg(0)); err != nil {
+			return err
+		}
+		if *secret == "" {
+			if *secret, err = newSecret(); err != nil {
+				return err
+			}
+		}
+		if err = cs.RotateSecret(ctx, fs.Arg(0), *secret); err != nil {
+			return err
+		}
+		info, err := cs.GetByID(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return c.printClient(newClientView(info, true))
+	}
+	return errUsage
+}
+
+func (c *cli) printClient(view *clientView) error {
+	if c.output == "json" {
+		return c.printJSON(view)
+	}
+	return c.printClients([]*clientView{view})
+}
+
+func (c *cli) printClients(views []*clientView) error {
+	if c.output == "json" {
+		return c.printJSON(views)
+	}
+
+	header := []string{"ID", "DOMAIN", "USER"}
+	withSecret := false
+	for _, v := range views {
+		withSecret = withSecret || v.Secret != ""
+	}
+	if withSecret {
+		header = append(header, "SECRET")
+	}
+
+	rows := [][]string{header}
+	for _, v := range views {
+		row := []string{v.ID, v.Domain, v.UserID}
+		if withSecret {
+			row = append(row, v.Secret)
+		}
+		rows = append(rows, row)
+	}
+	return c.printTable(rows)
+}
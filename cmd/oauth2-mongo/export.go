@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strconv"
+
+	mongo "gopkg.in/go-oauth2/mongo.v3"
+)
+
+// exportCommand write the clients and the active grants as NDJSON, to the standard output by default
+func (c *cli) exportCommand(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := fs.String("file", "-", "output file, - for the standard output")
+	excludeSecrets := fs.Bool("exclude-secrets", false, "export the clients without their secret, they are only imported over existing ones")
+	excludeTokens := fs.Bool("exclude-tokens", false, "export the grants without their tokens, they cannot be imported")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	cs, err := c.clientStore(ctx)
+	if err != nil {
+		return err
+	}
+	ts, err := c.tokenStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := c.stdout
+	if *file != "-" {
+		// the export holds the client secrets and the tokens
+		var f *os.File
+		f, err = os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = f
+	}
+
+	opts := &mongo.ExportOptions{ExcludeSecrets: *excludeSecrets, ExcludeTokenValues: *excludeTokens}
+	return mongo.Export(ctx, w, cs, ts, opts)
+}
+
+// importCommand read the records of an export, from the standard input by default
+func (c *cli) importCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "-", "input file, - for the standard input")
+	overwrite := fs.Bool("overwrite", false, "replace the clients and the grants already stored, they are skipped by default")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	r := c.stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	cs, err := c.clientStore(ctx)
+	if err != nil {
+		return err
+	}
+	ts, err := c.tokenStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := mongo.Import(ctx, r, cs, ts, &mongo.ImportOptions{Overwrite: *overwrite})
+	if res != nil {
+		if printErr := c.printImport(res); err == nil {
+			err = printErr
+		}
+	}
+	return err
+}
+
+func (c *cli) printImport(res *mongo.ImportResult) error {
+	if c.output == "json" {
+		return c.printJSON(res)
+	}
+	return c.printTable([][]string{
+		{"CLIENTS", "GRANTS", "SKIPPED"},
+		{strconv.Itoa(res.Clients), strconv.Itoa(res.Grants), strconv.Itoa(res.Skipped)},
+	})
+}
@@ -0,0 +1,321 @@
+// Command oauth2-mongo administers the clients and the tokens stored by gopkg.in/go-oauth2/mongo.v3
+//
+//	oauth2-mongo [flags] client create|show|list|update|delete|rotate-secret ...
+//	oauth2-mongo [flags] token show|revoke ...
+//	oauth2-mongo [flags] stats
+//	oauth2-mongo [flags] migrate [-dry-run]
+//	oauth2-mongo [flags] export|import ...
+//
+// The connection is read from the flags, or from the OAUTH2_MONGO_* environment variables.
+// With a field-level encryption, the key-encryption keys are given by -keks or OAUTH2_MONGO_KEKS.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	mongo "gopkg.in/go-oauth2/mongo.v3"
+)
+
+const usage = `usage: oauth2-mongo [flags] <command> [args]
+
+commands:
+  client create [-id id] [-secret secret] [-domain domain] [-user user]
+  client show [-show-secret] <id>
+  client list [-show-secret] [-after id] [-limit n]
+  client update [-domain domain] [-user user] <id>
+  client delete <id>
+  client rotate-secret [-secret secret] <id>
+  token show <token>
+  token revoke [-user user] [-client client]
+  stats
+  migrate [-dry-run]
+  export [-file path] [-exclude-secrets] [-exclude-tokens]
+  import [-file path] [-overwrite]
+
+flags:
+`
+
+// errUsage is returned for an invalid command line, the usage is printed
+var errUsage = errors.New("invalid command line")
+
+// cli hold the connection configuration and the output of a run
+type cli struct {
+	cfg     *mongo.Config
+	tenant  string
+	output  string
+	keks    string
+	timeout time.Duration
+	stdin   io.Reader
+	stdout  io.Writer
+
+	client      *mongodriver.Client
+	kekProvider mongo.KEKProvider
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run execute the command line and return the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	c := &cli{stdin: os.Stdin, stdout: stdout}
+	fs := c.flags(stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	err := c.dispatch(fs.Args())
+	if err == errUsage {
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// flags declare the global flags, their default is read from the environment
+func (c *cli) flags(stderr io.Writer) *flag.FlagSet {
+	c.cfg = &mongo.Config{}
+	fs := flag.NewFlagSet("oauth2-mongo", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&c.cfg.URL, "url", env("OAUTH2_MONGO_URL", "mongodb://localhost:27017"), "mongodb connection string (OAUTH2_MONGO_URL)")
+	fs.StringVar(&c.cfg.DB, "db", env("OAUTH2_MONGO_DB", "oauth2"), "database (OAUTH2_MONGO_DB)")
+	fs.StringVar(&c.cfg.Username, "username", env("OAUTH2_MONGO_USERNAME", ""), "username (OAUTH2_MONGO_USERNAME)")
+	fs.StringVar(&c.cfg.Password, "password", env("OAUTH2_MONGO_PASSWORD", ""), "password (OAUTH2_MONGO_PASSWORD)")
+	// the transactions journal of a service is cleaned up on start, never use the name of a running instance
+	fs.StringVar(&c.cfg.Service, "service", env("OAUTH2_MONGO_SERVICE", "oauth2-mongo-cli"), "service name, distinct from the running instances (OAUTH2_MONGO_SERVICE)")
+	fs.BoolVar(&c.cfg.IsReplicaSet, "replica-set", envBool("OAUTH2_MONGO_REPLICA_SET"), "the deployment is a replicaSet (OAUTH2_MONGO_REPLICA_SET)")
+	fs.BoolVar(&c.cfg.IsSharded, "sharded", envBool("OAUTH2_MONGO_SHARDED"), "the url targets the mongos of a sharded cluster (OAUTH2_MONGO_SHARDED)")
+	fs.StringVar(&c.tenant, "tenant", env("OAUTH2_MONGO_TENANT", ""), "tenant of a multi-tenant deployment (OAUTH2_MONGO_TENANT)")
+	fs.StringVar(&c.output, "output", env("OAUTH2_MONGO_OUTPUT", "table"), "output format, table or json (OAUTH2_MONGO_OUTPUT)")
+	fs.StringVar(&c.keks, "keks", env("OAUTH2_MONGO_KEKS", ""), "key-encryption keys of the encrypted fields, id=base64 key separated by commas, the first one wraps the new keys (OAUTH2_MONGO_KEKS)")
+	fs.DurationVar(&c.timeout, "timeout", 30*time.Second, "timeout of the command")
+	return fs
+}
+
+func env(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func envBool(key string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(key))
+	return v
+}
+
+func (c *cli) dispatch(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if c.output != "table" && c.output != "json" {
+		return fmt.Errorf("unknown output %q, table or json", c.output)
+	}
+	if c.cfg.IsSharded {
+		c.cfg.IsReplicaSet = true
+	}
+	if c.keks != "" {
+		provider, err := parseKEKs(c.keks)
+		if err != nil {
+			return err
+		}
+		c.kekProvider = provider
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if c.tenant != "" {
+		ctx = mongo.WithTenant(ctx, c.tenant)
+	}
+
+	err := c.command(ctx, args)
+	if errors.Is(err, mongo.ErrUnknownKEK) {
+		return fmt.Errorf("%w, set the key-encryption keys with -keks", err)
+	}
+	return err
+}
+
+func (c *cli) command(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "client":
+		return c.clientCommand(ctx, args[1:])
+	case "token":
+		return c.tokenCommand(ctx, args[1:])
+	case "stats":
+		return c.stats(ctx)
+	case "migrate":
+		return c.migrate(ctx, args[1:])
+	case "export":
+		return c.exportCommand(ctx, args[1:])
+	case "import":
+		return c.importCommand(ctx, args[1:])
+	}
+	return errUsage
+}
+
+// connect open the connection shared by the stores of the run
+func (c *cli) connect(ctx context.Context) error {
+	if c.client != nil {
+		return nil
+	}
+
+	clientOptions := options.Client().ApplyURI(c.cfg.URL)
+	if (!c.cfg.IsReplicaSet || c.cfg.IsSharded) && c.cfg.Username != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username: c.cfg.Username,
+			Password: c.cfg.Password,
+		})
+	}
+
+	client, err := mongodriver.Connect(ctx, clientOptions)
+	if err != nil {
+		return err
+	}
+	if err = client.Ping(ctx, nil); err != nil {
+		return err
+	}
+	c.client = client
+	return nil
+}
+
+// parseKEKs read the id=base64 key list of -keks, the first key is the active one
+func parseKEKs(s string) (mongo.KEKProvider, error) {
+	var activeID string
+	keys := map[string][]byte{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid key-encryption key %q, id=base64 key", pair)
+		}
+		kek, err := base64.StdEncoding.DecodeString(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key-encryption key %s: %w", kv[0], err)
+		}
+		if n := len(kek); n != 16 && n != 24 && n != 32 {
+			return nil, fmt.Errorf("invalid key-encryption key %s, 16, 24 or 32 bytes", kv[0])
+		}
+		if activeID == "" {
+			activeID = kv[0]
+		}
+		keys[kv[0]] = kek
+	}
+	return mongo.NewLocalKEKProvider(activeID, keys), nil
+}
+
+func (c *cli) storeConfig() *mongo.StoreConfig {
+	sc := mongo.NewStoreConfig(0, 0).SetMultiTenant(c.tenant != "")
+	if c.kekProvider != nil {
+		sc.SetFieldEncryption(c.kekProvider)
+	}
+	return sc
+}
+
+// tokenStore create the token store, the store constructors panic on an index error
+func (c *cli) tokenStore(ctx context.Context) (ts *mongo.TokenStore, err error) {
+	if err = c.connect(ctx); err != nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return mongo.NewTokenStoreWithSession(c.client, c.cfg, c.storeConfig()), nil
+}
+
+func (c *cli) clientStore(ctx context.Context) (*mongo.ClientStore, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+	return mongo.NewClientStoreWithSession(c.client, c.cfg, c.storeConfig()), nil
+}
+
+func (c *cli) stats(ctx context.Context) error {
+	ts, err := c.tokenStore(ctx)
+	if err != nil {
+		return err
+	}
+	tokenStats, err := ts.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	cs, err := c.clientStore(ctx)
+	if err != nil {
+		return err
+	}
+	clientStats, err := cs.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	stats := append(clientStats, tokenStats...)
+	if c.output == "json" {
+		return c.printJSON(stats)
+	}
+	rows := [][]string{{"COLLECTION", "COUNT", "SIZE", "STORAGE SIZE", "INDEXES", "INDEX SIZE"}}
+	for _, s := range stats {
+		rows = append(rows, []string{
+			s.Name,
+			strconv.FormatInt(s.Count, 10),
+			strconv.FormatInt(s.Size, 10),
+			strconv.FormatInt(s.StorageSize, 10),
+			strconv.FormatInt(s.Indexes, 10),
+			strconv.FormatInt(s.TotalIndexSize, 10),
+		})
+	}
+	return c.printTable(rows)
+}
+
+func (c *cli) migrate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report the pending migrations without applying them")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	// the migrations of the module for the collections of the token store
+	ts, err := c.tokenStore(ctx)
+	if err != nil {
+		return err
+	}
+	mcfg := ts.DefaultMigrationConfig()
+	mcfg.DryRun = *dryRun
+	migrations, err := mongo.NewMigratorWithSession(c.client, c.cfg, mcfg, c.storeConfig()).Migrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		views := make([]mongo.AppliedMigration, 0, len(migrations))
+		for _, m := range migrations {
+			views = append(views, mongo.AppliedMigration{Version: m.Version, Description: m.Description})
+		}
+		return c.printJSON(views)
+	}
+	rows := [][]string{{"VERSION", "DESCRIPTION"}}
+	for _, m := range migrations {
+		rows = append(rows, []string{strconv.Itoa(m.Version), m.Description})
+	}
+	return c.printTable(rows)
+}
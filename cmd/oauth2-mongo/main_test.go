@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommandLine(t *testing.T) {
+	Convey("Test command line parsing", t, func() {
+		var stdout, stderr bytes.Buffer
+
+		Convey("Test usage", func() {
+			So(run(nil, &stdout, &stderr), ShouldEqual, 2)
+			So(stderr.String(), ShouldContainSubstring, "usage: oauth2-mongo")
+
+			So(run([]string{"client", "rename", "id"}, &stdout, &stderr), ShouldEqual, 2)
+			So(run([]string{"token", "revoke"}, &stdout, &stderr), ShouldEqual, 2)
+			So(run([]string{"export", "clients"}, &stdout, &stderr), ShouldEqual, 2)
+			So(run([]string{"import", "-skip"}, &stdout, &stderr), ShouldEqual, 2)
+		})
+
+		Convey("Test environment", func() {
+			os.Setenv("OAUTH2_MONGO_DB", "oauth2_env")
+			os.Setenv("OAUTH2_MONGO_REPLICA_SET", "true")
+			defer os.Unsetenv("OAUTH2_MONGO_DB")
+			defer os.Unsetenv("OAUTH2_MONGO_REPLICA_SET")
+
+			c := &cli{}
+			fs := c.flags(&stderr)
+			So(fs.Parse([]string{"-url", "mongodb://db:27017"}), ShouldBeNil)
+			So(c.cfg.URL, ShouldEqual, "mongodb://db:27017")
+			So(c.cfg.DB, ShouldEqual, "oauth2_env")
+			So(c.cfg.IsReplicaSet, ShouldBeTrue)
+			So(c.cfg.Service, ShouldEqual, "oauth2-mongo-cli")
+		})
+
+		Convey("Test key-encryption keys", func() {
+			k1 := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+			k2 := base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))
+
+			provider, err := parseKEKs("k2=" + k2 + ", k1=" + k1)
+			So(err, ShouldBeNil)
+			id, kek, err := provider.ActiveKEK(context.TODO())
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "k2")
+			So(string(kek), ShouldEqual, "fedcba9876543210")
+			kek, err = provider.KEK(context.TODO(), "k1")
+			So(err, ShouldBeNil)
+			So(len(kek), ShouldEqual, 32)
+
+			_, err = parseKEKs("k1")
+			So(err, ShouldNotBeNil)
+			_, err = parseKEKs("k1=not base64")
+			So(err, ShouldNotBeNil)
+			_, err = parseKEKs("k1=" + base64.StdEncoding.EncodeToString([]byte("short")))
+			So(err, ShouldNotBeNil)
+
+			So(run([]string{"-keks", "k1", "stats"}, &stdout, &stderr), ShouldEqual, 1)
+			So(stderr.String(), ShouldContainSubstring, "invalid key-encryption key")
+		})
+
+		Convey("Test table output", func() {
+			c := &cli{stdout: &stdout, output: "table"}
+			err := c.printClients([]*clientView{{ID: "app", Domain: "http://localhost", UserID: "user"}})
+			So(err, ShouldBeNil)
+			So(stdout.String(), ShouldEqual, "ID   DOMAIN            USER\napp  http://localhost  user\n")
+		})
+	})
+}
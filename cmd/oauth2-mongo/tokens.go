@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	mongo "gopkg.in/go-oauth2/mongo.v3"
+)
+
+// tokenView is the output of a token lookup, it never holds the other token values of the grant
+type tokenView struct {
+	Type        string    `json:"type"`
+	ClientID    string    `json:"client_id"`
+	UserID      string    `json:"user_id"`
+	Scope       string    `json:"scope"`
+	RedirectURI string    `json:"redirect_uri,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func newTokenView(typ string, ti oauth2.TokenInfo) *tokenView {
+	v := &tokenView{
+		Type:        typ,
+		ClientID:    ti.GetClientID(),
+		UserID:      ti.GetUserID(),
+		Scope:       ti.GetScope(),
+		RedirectURI: ti.GetRedirectURI(),
+	}
+	switch typ {
+	case "code":
+		v.CreatedAt, v.ExpiresAt = ti.GetCodeCreateAt(), ti.GetCodeCreateAt().Add(ti.GetCodeExpiresIn())
+	case "access":
+		v.CreatedAt, v.ExpiresAt = ti.GetAccessCreateAt(), ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn())
+	case "refresh":
+		v.CreatedAt, v.ExpiresAt = ti.GetRefreshCreateAt(), ti.GetRefreshCreateAt().Add(ti.GetRefreshExpiresIn())
+	}
+	return v
+}
+
+func (c *cli) tokenCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	user := fs.String("user", "", "revoke the tokens of the user")
+	client := fs.String("client", "", "revoke the tokens of the client")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "show":
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		ts, err := c.tokenStore(ctx)
+		if err != nil {
+			return err
+		}
+		view, err := lookupToken(ctx, ts, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return c.printJSON(view)
+		}
+		return c.printTable([][]string{
+			{"TYPE", "CLIENT", "USER", "SCOPE", "CREATED", "EXPIRES"},
+			{view.Type, view.ClientID, view.UserID, view.Scope, view.CreatedAt.Format(time.RFC3339), view.ExpiresAt.Format(time.RFC3339)},
+		})
+
+	case "revoke":
+		if fs.NArg() != 0 || (*user == "" && *client == "") {
+			return errUsage
+		}
+		ts, err := c.tokenStore(ctx)
+		if err != nil {
+			return err
+		}
+		switch {
+		case *user != "" && *client != "":
+			return ts.RevokeByUserClient(ctx, *user, *client)
+		case *user != "":
+			return ts.RevokeByUser(ctx, *user)
+		default:
+			return ts.RevokeByClient(ctx, *client)
+		}
+	}
+	return errUsage
+}
+
+// lookupToken find the token as an access token, a refresh token then an authorization code
+func lookupToken(ctx context.Context, ts *mongo.TokenStore, token string) (*tokenView, error) {
+	ti, err := ts.GetByAccess(ctx, token)
+	if err != nil && err != mongodriver.ErrNoDocuments {
+		return nil, err
+	}
+	if ti != nil {
+		return newTokenView("access", ti), nil
+	}
+
+	ti, err = ts.GetByRefresh(ctx, token)
+	if err != nil && err != mongodriver.ErrNoDocuments {
+		return nil, err
+	}
+	if ti != nil {
+		return newTokenView("refresh", ti), nil
+	}
+
+	ti, err = ts.GetByCode(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if ti != nil {
+		return newTokenView("code", ti), nil
+	}
+	return nil, mongodriver.ErrNoDocuments
+}
@@ -0,0 +1,254 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauth2errors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrCodeReplayed is returned when an authorization code is redeemed more than once
+var ErrCodeReplayed = errors.New("authorization code already redeemed")
+
+// SetCodeSingleUse make GetByCode consume the authorization code atomically,
+// a second redemption finds no code and revokes the tokens issued by the first one(RFC 6749 section 4.1.2)
+// consumed codes are kept until they expire so that replays can be detected
+func (sc *StoreConfig) SetCodeSingleUse(enabled bool) *StoreConfig {
+	sc.codeSingleUse = enabled
+	return sc
+}
+
+type codeExchangeKey struct{}
+
+// codeExchange carry the code consumed by GetByCode, or the grant of the refresh token read by GetByRefresh,
+// to the Create of the tokens issued from it
+type codeExchange struct {
+	mu    sync.Mutex
+	code  string
+	grant string
+}
+
+func (ce *codeExchange) set(code string) {
+	ce.mu.Lock()
+	ce.code = code
+	ce.mu.Unlock()
+}
+
+func (ce *codeExchange) setGrant(grant string) {
+	ce.mu.Lock()
+	ce.grant = grant
+	ce.mu.Unlock()
+}
+
+// take return the code consumed and the grant the new tokens belong to, the code itself on a code exchange
+func (ce *codeExchange) take() (code, grant string) {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	code, grant = ce.code, ce.grant
+	if code != "" {
+		grant = code
+	}
+	ce.code, ce.grant = "", ""
+	return
+}
+
+// WithCodeExchange prepare the context of a token request so that the tokens issued
+// from an authorization code are linked to it and revoked if the code is replayed
+func WithCodeExchange(ctx context.Context) context.Context {
+	return context.WithValue(ctx, codeExchangeKey{}, &codeExchange{})
+}
+
+func codeExchangeFrom(ctx context.Context) *codeExchange {
+	if ctx == nil {
+		return nil
+	}
+	ce, _ := ctx.Value(codeExchangeKey{}).(*codeExchange)
+	return ce
+}
+
+// ConsumeCode mark the authorization code as redeemed and return its token information
+// nil is returned for an unknown or expired code, ErrCodeReplayed for a code already redeemed,
+// in which case the tokens issued from it are revoked
+func (ts *TokenStore) ConsumeCode(ctx context.Context, code string) (ti oauth2.TokenInfo, err error) {
+	if err := ts.tenant(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.ConsumeCode", "update", ts.tcfg.BasicCName)
+	defer func() { op.endLookup(ti != nil, err) }()
+
+	ti, err = ts.consumeCode(ctx, code)
+	return
+}
+
+func (ts *TokenStore) consumeCode(ctx context.Context, code string) (ti oauth2.TokenInfo, err error) {
+	bd, err := ts.consumeCodeData(ctx, code)
+	if err != nil || bd == nil {
+		return
+	}
+
+	data, err := ts.openData(ctx, bd)
+	if err != nil {
+		return
+	}
+
+	var tm models.Token
+	if err = json.Unmarshal(data, &tm); err != nil {
+		return
+	}
+	ti = &tm
+
+	ts.tcfg.storeConfig.audit(ctx, tokenAuditEvent(AuditCodeConsumed, "code", ti))
+	return
+}
+
+// consumeCodeData set the consumed marker of the code document and return it,
+// only one of concurrent callers gets the document
+func (ts *TokenStore) consumeCodeData(ctx context.Context, code string) (*basicData, error) {
+	ce := codeExchangeFrom(ctx)
+
+	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	var bd basicData
+	err := ts.c(ctx, ts.tcfg.BasicCName, ts.tcfg.storeConfig.writeOptions(WriteTokenRevoke)).FindOneAndUpdate(ctx,
+		// an expired code is removed by the TTL monitor up to a minute later
+		bson.M{"_id": code, "ConsumedAt": bson.M{"$exists": false}, "ExpiredAt": bson.M{"$gt": time.Now()}},
+		bson.M{"$set": bson.M{"ConsumedAt": time.Now()}},
+	).Decode(&bd)
+	if err == mongo.ErrNoDocuments {
+		return nil, ts.detectReplay(ctx, code)
+	}
+	if err != nil {
+		ts.tcfg.storeConfig.log().Error("Error ConsumeCode", "operation", "update", "collection", ts.tcfg.BasicCName, "error", err)
+		return nil, err
+	}
+
+	if ce != nil {
+		ce.set(code)
+	}
+	return &bd, nil
+}
+
+// ConsumeCodeWithVerifier consume the authorization code then verify the PKCE code_verifier
+// against the challenge stored with it, a failed verification burns the code
+// the errors of the oauth2 errors package are returned so that the server answers invalid_grant
+func (ts *TokenStore) ConsumeCodeWithVerifier(ctx context.Context, code, verifier string) (ti oauth2.TokenInfo, err error) {
+	if err := ts.tenant(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.ConsumeCodeWithVerifier", "update", ts.tcfg.BasicCName)
+	defer func() { op.endLookup(ti != nil, err) }()
+
+	bd, err := ts.consumeCodeData(ctx, code)
+	if err != nil || bd == nil {
+		return
+	}
+
+	if err = verifyCodeChallenge(bd.CodeChallenge, bd.CodeChallengeMethod, verifier); err != nil {
+		ts.tcfg.storeConfig.log().Warn("Code verifier rejected", "collection", ts.tcfg.BasicCName, "error", err)
+		return
+	}
+
+	data, err := ts.openData(ctx, bd)
+	if err != nil {
+		return
+	}
+
+	var tm models.Token
+	if err = json.Unmarshal(data, &tm); err != nil {
+		return
+	}
+	ti = &tm
+
+	ts.tcfg.storeConfig.audit(ctx, tokenAuditEvent(AuditCodeConsumed, "code", ti))
+	return
+}
+
+// verifyCodeChallenge check the code_verifier with the plain or S256 method(RFC 7636 section 4.6)
+func verifyCodeChallenge(challenge, method, verifier string) error {
+	if challenge == "" {
+		if verifier != "" {
+			return oauth2errors.ErrInvalidCodeChallenge
+		}
+		return nil
+	}
+	if verifier == "" {
+		return oauth2errors.ErrMissingCodeVerifier
+	}
+
+	ccm := oauth2.CodeChallengeMethod(method)
+	if method == "" {
+		ccm = oauth2.CodeChallengePlain
+	}
+	if ccm != oauth2.CodeChallengePlain && ccm != oauth2.CodeChallengeS256 {
+		return oauth2errors.ErrInvalidCodeChallenge
+	}
+	if !ccm.Validate(challenge, verifier) {
+		return oauth2errors.ErrInvalidCodeChallenge
+	}
+	return nil
+}
+
+// detectReplay flag a consumed code as replayed and revoke the tokens issued from it,
+// including the ones rotated since by refresh
+func (ts *TokenStore) detectReplay(ctx context.Context, code string) error {
+	var bd basicData
+	err := ts.c(ctx, ts.tcfg.BasicCName, ts.tcfg.storeConfig.writeOptions(WriteTokenRevoke)).FindOneAndUpdate(ctx,
+		bson.M{"_id": code, "ConsumedAt": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"ReplayedAt": time.Now()}},
+	).Decode(&bd)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ts.tcfg.storeConfig.log().Warn("Authorization code replayed", "collection", ts.tcfg.BasicCName, "issued", bd.IssuedBasicID != "")
+	if err := ts.revokeMatching(ctx, bson.M{"GrantID": code}); err != nil {
+		return err
+	}
+	// token data written before GrantID only carry the link on the code
+	if bd.IssuedBasicID != "" {
+		if err := ts.revokeBasic(ctx, bd.IssuedBasicID); err != nil {
+			return err
+		}
+	}
+	return ErrCodeReplayed
+}
+
+// linkIssuedTokens record on the code the token data issued from it,
+// the tokens are revoked at once if the code has been replayed meanwhile
+func (ts *TokenStore) linkIssuedTokens(ctx context.Context, code, basicID string) error {
+	var bd basicData
+	err := ts.c(ctx, ts.tcfg.BasicCName, ts.tcfg.storeConfig.writeOptions(WriteTokenCreate)).FindOneAndUpdate(ctx,
+		bson.M{"_id": code},
+		bson.M{"$set": bson.M{"IssuedBasicID": basicID}},
+	).Decode(&bd)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if bd.ReplayedAt != nil {
+		if err := ts.revokeBasic(ctx, basicID); err != nil {
+			return err
+		}
+		return ErrCodeReplayed
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package mongo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauth2errors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCodeExchange(t *testing.T) {
+	Convey("Test code exchange context", t, func() {
+		So(codeExchangeFrom(context.TODO()), ShouldBeNil)
+
+		ctx := WithCodeExchange(context.TODO())
+		ce := codeExchangeFrom(ctx)
+		So(ce, ShouldNotBeNil)
+
+		ce.set("code")
+		code, grant := codeExchangeFrom(ctx).take()
+		So(code, ShouldEqual, "code")
+		So(grant, ShouldEqual, "code")
+		code, grant = ce.take()
+		So(code, ShouldEqual, "")
+		So(grant, ShouldEqual, "")
+
+		ce.setGrant("grant")
+		code, grant = ce.take()
+		So(code, ShouldEqual, "")
+		So(grant, ShouldEqual, "grant")
+	})
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	Convey("Test PKCE code verifier", t, func() {
+		verifier := "dBjftJeZ4CVP-mJ92K9Pw0YeE4c6ZDgEVoGHnj7w1dHqGkU"
+		sum := sha256.Sum256([]byte(verifier))
+		challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+		So(verifyCodeChallenge("", "", ""), ShouldBeNil)
+		So(verifyCodeChallenge("", "", verifier), ShouldEqual, oauth2errors.ErrInvalidCodeChallenge)
+		So(verifyCodeChallenge(challenge, "S256", ""), ShouldEqual, oauth2errors.ErrMissingCodeVerifier)
+
+		So(verifyCodeChallenge(challenge, "S256", verifier), ShouldBeNil)
+		So(verifyCodeChallenge(challenge, "S256", verifier+"x"), ShouldEqual, oauth2errors.ErrInvalidCodeChallenge)
+
+		So(verifyCodeChallenge(verifier, "plain", verifier), ShouldBeNil)
+		So(verifyCodeChallenge(verifier, "", verifier), ShouldBeNil)
+		So(verifyCodeChallenge(challenge, "", verifier), ShouldEqual, oauth2errors.ErrInvalidCodeChallenge)
+		So(verifyCodeChallenge(verifier, "S512", verifier), ShouldEqual, oauth2errors.ErrInvalidCodeChallenge)
+	})
+}
+
+func TestTokenStoreCodeSingleUse(t *testing.T) {
+	Convey("Test single-use authorization codes", t, func() {
+		storeConfig := NewStoreConfig(0, 0).SetCodeSingleUse(true)
+
+		var store *TokenStore
+		if !isReplicaSet {
+			store = NewTokenStore(NewConfigNonReplicaSet(url, dbName, username, password, service), storeConfig)
+		} else {
+			store = NewTokenStore(NewConfigReplicaSet(url, dbName), storeConfig)
+		}
+
+		code := &models.Token{
+			ClientID:      "1",
+			UserID:        "1_5",
+			RedirectURI:   "http://localhost/",
+			Scope:         "all",
+			Code:          "15_15_15",
+			CodeCreateAt:  time.Now(),
+			CodeExpiresIn: time.Second * 5,
+		}
+		err := store.Create(context.TODO(), code)
+		So(err, ShouldBeNil)
+
+		// first redemption
+		ctx := WithCodeExchange(context.TODO())
+		cinfo, err := store.GetByCode(ctx, code.Code)
+		So(err, ShouldBeNil)
+		So(cinfo.GetUserID(), ShouldEqual, code.UserID)
+
+		err = store.RemoveByCode(ctx, code.Code)
+		So(err, ShouldBeNil)
+
+		token := &models.Token{
+			ClientID:         "1",
+			UserID:           "1_5",
+			Scope:            "all",
+			Access:           "1_5_1",
+			AccessCreateAt:   time.Now(),
+			AccessExpiresIn:  time.Second * 5,
+			Refresh:          "1_5_2",
+			RefreshCreateAt:  time.Now(),
+			RefreshExpiresIn: time.Second * 15,
+		}
+		err = store.Create(ctx, token)
+		So(err, ShouldBeNil)
+
+		ainfo, err := store.GetByAccess(context.TODO(), token.Access)
+		So(err, ShouldBeNil)
+		So(ainfo.GetUserID(), ShouldEqual, token.UserID)
+
+		// refresh rotation
+		ctx = WithCodeExchange(context.TODO())
+		rinfo, err := store.GetByRefresh(ctx, token.Refresh)
+		So(err, ShouldBeNil)
+		So(rinfo.GetUserID(), ShouldEqual, token.UserID)
+
+		rotated := &models.Token{
+			ClientID:         "1",
+			UserID:           "1_5",
+			Scope:            "all",
+			Access:           "1_5_3",
+			AccessCreateAt:   time.Now(),
+			AccessExpiresIn:  time.Second * 5,
+			Refresh:          "1_5_4",
+			RefreshCreateAt:  time.Now(),
+			RefreshExpiresIn: time.Second * 15,
+		}
+		err = store.Create(ctx, rotated)
+		So(err, ShouldBeNil)
+
+		_, err = store.GetByAccess(context.TODO(), rotated.Access)
+		So(err, ShouldBeNil)
+
+		// replay
+		cinfo, err = store.GetByCode(WithCodeExchange(context.TODO()), code.Code)
+		So(err, ShouldBeNil)
+		So(cinfo, ShouldBeNil)
+
+		_, err = store.ConsumeCode(context.TODO(), code.Code)
+		So(err, ShouldEqual, ErrCodeReplayed)
+
+		_, err = store.GetByAccess(context.TODO(), token.Access)
+		So(err.Error(), ShouldEqual, "mongo: no documents in result")
+
+		_, err = store.GetByRefresh(context.TODO(), token.Refresh)
+		So(err.Error(), ShouldEqual, "mongo: no documents in result")
+
+		_, err = store.GetByAccess(context.TODO(), rotated.Access)
+		So(err.Error(), ShouldEqual, "mongo: no documents in result")
+
+		_, err = store.GetByRefresh(context.TODO(), rotated.Refresh)
+		So(err.Error(), ShouldEqual, "mongo: no documents in result")
+
+		// unknown code
+		cinfo, err = store.ConsumeCode(context.TODO(), "unknown")
+		So(err, ShouldBeNil)
+		So(cinfo, ShouldBeNil)
+
+		// expired code, not removed yet by the TTL monitor
+		expired := &models.Token{
+			ClientID:      "1",
+			UserID:        "1_5",
+			Code:          "15_15_16",
+			CodeCreateAt:  time.Now().Add(-time.Minute),
+			CodeExpiresIn: time.Second * 5,
+		}
+		So(store.Create(context.TODO(), expired), ShouldBeNil)
+		cinfo, err = store.ConsumeCode(context.TODO(), expired.Code)
+		So(err, ShouldBeNil)
+		So(cinfo, ShouldBeNil)
+	})
+}
+
+func TestTokenStoreConsumeCodeWithVerifier(t *testing.T) {
+	Convey("Test PKCE authorization codes", t, func() {
+		var store *TokenStore
+		if !isReplicaSet {
+			store = NewTokenStore(NewConfigNonReplicaSet(url, dbName, username, password, service))
+		} else {
+			store = NewTokenStore(NewConfigReplicaSet(url, dbName))
+		}
+
+		verifier := "dBjftJeZ4CVP-mJ92K9Pw0YeE4c6ZDgEVoGHnj7w1dHqGkU"
+		sum := sha256.Sum256([]byte(verifier))
+
+		newCode := func(code string) *models.Token {
+			return &models.Token{
+				ClientID:            "1",
+				UserID:              "1_6",
+				RedirectURI:         "http://localhost/",
+				Scope:               "all",
+				Code:                code,
+				CodeCreateAt:        time.Now(),
+				CodeExpiresIn:       time.Second * 5,
+				CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+				CodeChallengeMethod: string(oauth2.CodeChallengeS256),
+			}
+		}
+
+		Convey("Test valid verifier", func() {
+			info := newCode("16_16_16")
+			err := store.Create(context.TODO(), info)
+			So(err, ShouldBeNil)
+
+			var bd basicData
+			err = store.c(context.TODO(), store.tcfg.BasicCName).FindOne(context.TODO(), bson.M{"_id": info.Code}).Decode(&bd)
+			So(err, ShouldBeNil)
+			So(bd.CodeChallenge, ShouldEqual, info.CodeChallenge)
+			So(bd.CodeChallengeMethod, ShouldEqual, "S256")
+
+			cinfo, err := store.ConsumeCodeWithVerifier(context.TODO(), info.Code, verifier)
+			So(err, ShouldBeNil)
+			So(cinfo.GetUserID(), ShouldEqual, info.UserID)
+
+			_, err = store.ConsumeCodeWithVerifier(context.TODO(), info.Code, verifier)
+			So(err, ShouldEqual, ErrCodeReplayed)
+		})
+
+		Convey("Test invalid verifier burns the code", func() {
+			info := newCode("16_16_17")
+			err := store.Create(context.TODO(), info)
+			So(err, ShouldBeNil)
+
+			cinfo, err := store.ConsumeCodeWithVerifier(context.TODO(), info.Code, "wrong")
+			So(err, ShouldEqual, oauth2errors.ErrInvalidCodeChallenge)
+			So(cinfo, ShouldBeNil)
+
+			_, err = store.ConsumeCodeWithVerifier(context.TODO(), info.Code, verifier)
+			So(err, ShouldEqual, ErrCodeReplayed)
+		})
+	})
+}
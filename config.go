@@ -8,6 +8,8 @@ type Config struct {
 	Password     string
 	Service      string
 	IsReplicaSet bool
+	// IsSharded the URL targets the mongos of a sharded cluster, see NewConfigSharded
+	IsSharded bool
 }
 
 // NewConfigNonReplicaSet create mongodb configuration for a non-replicaSet
@@ -23,6 +25,21 @@ func NewConfigNonReplicaSet(url, db, username, password, service string) *Config
 	return config
 }
 
+// NewConfigSharded create mongodb configuration for a sharded cluster(MongoDB 4.2+),
+// where the tokens are created in distributed transactions as with a replicaSet
+func NewConfigSharded(url, db, username, password, service string) *Config {
+	config := &Config{
+		URL:          url,
+		DB:           db,
+		Username:     username,
+		Password:     password,
+		Service:      service,
+		IsReplicaSet: true,
+		IsSharded:    true,
+	}
+	return config
+}
+
 // NewConfigReplicaSet create mongodb configuration for a ReplicaSet
 func NewConfigReplicaSet(url, db string) *Config {
 	config := &Config{
@@ -0,0 +1,215 @@
+package mongo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Consent is the approval of scopes by a user for a client
+type Consent struct {
+	UserID    string    `bson:"UserID"`
+	ClientID  string    `bson:"ClientID"`
+	Scopes    []string  `bson:"Scopes"`
+	GrantedAt time.Time `bson:"GrantedAt"`
+	UpdatedAt time.Time `bson:"UpdatedAt"`
+	// ExpiredAt is zero for a consent without expiry
+	ExpiredAt time.Time `bson:"ExpiredAt,omitempty"`
+}
+
+// Covers report whether all the space separated scopes have been approved
+func (c *Consent) Covers(scope string) bool {
+	granted := make(map[string]bool, len(c.Scopes))
+	for _, s := range c.Scopes {
+		granted[s] = true
+	}
+	for _, s := range strings.Fields(scope) {
+		if !granted[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// ConsentConfig consent collection configuration parameters
+type ConsentConfig struct {
+	// store consents collection name(The default is oauth2_consent)
+	ConsentCName string
+	// when set, revoking a consent also revokes the codes and tokens of the user and the client
+	CascadeTokenStore *TokenStore
+}
+
+// NewDefaultConsentConfig create a default consent configuration, without cascade
+func NewDefaultConsentConfig() *ConsentConfig {
+	return &ConsentConfig{
+		ConsentCName: "oauth2_consent",
+	}
+}
+
+// ConsentStore MongoDB storage for the consents, keyed by user id and client id
+type ConsentStore struct {
+	ccfg   *ConsentConfig
+	scfg   *StoreConfig
+	client *mongo.Client
+}
+
+// NewConsentStoreWithSession create a consent store instance based on mongodb
+func NewConsentStoreWithSession(client *mongo.Client, cfg *Config, ccfg *ConsentConfig, scfgs ...*StoreConfig) *ConsentStore {
+	if ccfg == nil {
+		ccfg = NewDefaultConsentConfig()
+	}
+
+	cs := &ConsentStore{
+		ccfg:   ccfg,
+		scfg:   NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet),
+		client: client,
+	}
+	cs.scfg.override(scfgs...)
+
+	_, err := cs.c().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "UserID", Value: 1}, {Key: "ClientID", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "ExpiredAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(1),
+		},
+	})
+	if err != nil {
+		cs.scfg.log().Error("Error creating index", "collection", ccfg.ConsentCName, "error", err)
+		panic(err)
+	}
+
+	return cs
+}
+
+func (cs *ConsentStore) c() *mongo.Collection {
+	return cs.client.Database(cs.scfg.db).Collection(cs.ccfg.ConsentCName, cs.scfg.writeOptions(""))
+}
+
+// Grant add the space separated scopes to the consent of the user for the client,
+// the scopes of an expired consent are replaced
+// the consent expires after expiresIn, or never when expiresIn is 0
+func (cs *ConsentStore) Grant(ctx context.Context, userID, clientID, scope string, expiresIn time.Duration) (err error) {
+	ctx, op := cs.scfg.startOperation(ctx, "ConsentStore.Grant", "update", cs.ccfg.ConsentCName)
+	defer func() { op.end(err) }()
+
+	ctxReq, cancel := cs.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	// the scopes are added in order, once
+	scopes := []string{}
+	seen := make(map[string]bool)
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	// an expired consent not removed yet by the TTL monitor is granted anew
+	now := time.Now()
+	live := bson.M{"$or": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": "$ExpiredAt"}, "missing"}},
+		bson.M{"$gt": bson.A{"$ExpiredAt", now}},
+	}}
+	granted := bson.M{"$ifNull": bson.A{"$Scopes", bson.A{}}}
+	// a scope starting with $ is not a field path
+	added := bson.M{"$literal": scopes}
+	set := bson.M{
+		"Scopes": bson.M{"$cond": bson.A{live,
+			bson.M{"$concatArrays": bson.A{granted, bson.M{"$filter": bson.M{
+				"input": added,
+				"cond":  bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$$this", granted}}}},
+			}}}},
+			added,
+		}},
+		"GrantedAt": bson.M{"$cond": bson.A{live, bson.M{"$ifNull": bson.A{"$GrantedAt", now}}, now}},
+		"UpdatedAt": now,
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: set}}}
+	if expiresIn > 0 {
+		set["ExpiredAt"] = now.Add(expiresIn)
+	} else {
+		update = append(update, bson.D{{Key: "$unset", Value: "ExpiredAt"}})
+	}
+
+	_, err = cs.c().UpdateOne(ctx, bson.M{"UserID": userID, "ClientID": clientID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		cs.scfg.log().Error("Error ConsentStore.Grant", "operation", "update", "collection", cs.ccfg.ConsentCName, "error", err)
+	}
+	return
+}
+
+// Get return the consent of the user for the client, nil when there is none or it has expired
+func (cs *ConsentStore) Get(ctx context.Context, userID, clientID string) (c *Consent, err error) {
+	ctx, op := cs.scfg.startOperation(ctx, "ConsentStore.Get", "find", cs.ccfg.ConsentCName)
+	defer func() { op.endLookup(c != nil, err) }()
+
+	ctxReq, cancel := cs.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	// the TTL monitor runs every minute, expired consents may still be there
+	var consent Consent
+	err = cs.c().FindOne(ctx, bson.M{
+		"UserID":   userID,
+		"ClientID": clientID,
+		"$or": bson.A{
+			bson.M{"ExpiredAt": bson.M{"$exists": false}},
+			bson.M{"ExpiredAt": bson.M{"$gt": time.Now()}},
+		},
+	}).Decode(&consent)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// IsGranted report whether the user has approved all the space separated scopes for the client,
+// in which case the consent screen can be skipped
+func (cs *ConsentStore) IsGranted(ctx context.Context, userID, clientID, scope string) (bool, error) {
+	c, err := cs.Get(ctx, userID, clientID)
+	if err != nil || c == nil {
+		return false, err
+	}
+	return c.Covers(scope), nil
+}
+
+// Revoke remove the consent of the user for the client,
+// and the codes and tokens issued to the client for the user when CascadeTokenStore is set
+func (cs *ConsentStore) Revoke(ctx context.Context, userID, clientID string) (err error) {
+	ctx, op := cs.scfg.startOperation(ctx, "ConsentStore.Revoke", "delete", cs.ccfg.ConsentCName)
+	defer func() { op.end(err) }()
+
+	ctxReq, cancel := cs.scfg.setRequestContext()
+	defer cancel()
+	delCtx := ctx
+	if ctxReq != nil {
+		delCtx = withSpan(ctxReq, ctx)
+	}
+
+	_, err = cs.c().DeleteOne(delCtx, bson.M{"UserID": userID, "ClientID": clientID})
+	if err != nil {
+		cs.scfg.log().Error("Error ConsentStore.Revoke", "operation", "delete", "collection", cs.ccfg.ConsentCName, "error", err)
+		return
+	}
+
+	if cs.ccfg.CascadeTokenStore != nil {
+		err = cs.ccfg.CascadeTokenStore.RevokeByUserClient(ctx, userID, clientID)
+	}
+	return
+}
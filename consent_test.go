@@ -0,0 +1,116 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConsentCovers(t *testing.T) {
+	Convey("Test consent scopes", t, func() {
+		c := &Consent{Scopes: []string{"read", "write"}}
+
+		So(c.Covers("read"), ShouldBeTrue)
+		So(c.Covers("write read"), ShouldBeTrue)
+		So(c.Covers(""), ShouldBeTrue)
+		So(c.Covers("read admin"), ShouldBeFalse)
+	})
+}
+
+func TestConsentStore(t *testing.T) {
+	Convey("Test mongodb consent store", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		tokenStore := NewTokenStore(cfg)
+		ccfg := NewDefaultConsentConfig()
+		ccfg.CascadeTokenStore = tokenStore
+		store := NewConsentStoreWithSession(tokenStore.client, cfg, ccfg)
+
+		Convey("Test grant", func() {
+			err := store.Grant(context.TODO(), "consent_user", "consent_client", "read", 0)
+			So(err, ShouldBeNil)
+			err = store.Grant(context.TODO(), "consent_user", "consent_client", "write read", 0)
+			So(err, ShouldBeNil)
+
+			c, err := store.Get(context.TODO(), "consent_user", "consent_client")
+			So(err, ShouldBeNil)
+			So(c.Scopes, ShouldResemble, []string{"read", "write"})
+			So(c.ExpiredAt.IsZero(), ShouldBeTrue)
+
+			granted, err := store.IsGranted(context.TODO(), "consent_user", "consent_client", "read write")
+			So(err, ShouldBeNil)
+			So(granted, ShouldBeTrue)
+
+			granted, err = store.IsGranted(context.TODO(), "consent_user", "other_client", "read")
+			So(err, ShouldBeNil)
+			So(granted, ShouldBeFalse)
+
+			err = store.Revoke(context.TODO(), "consent_user", "consent_client")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Test expiry", func() {
+			err := store.Grant(context.TODO(), "consent_user", "expiring_client", "read", time.Millisecond)
+			So(err, ShouldBeNil)
+
+			time.Sleep(10 * time.Millisecond)
+			c, err := store.Get(context.TODO(), "consent_user", "expiring_client")
+			So(err, ShouldBeNil)
+			So(c, ShouldBeNil)
+
+			// granted anew before the TTL monitor removes it
+			err = store.Grant(context.TODO(), "consent_user", "expiring_client", "write", 0)
+			So(err, ShouldBeNil)
+
+			c, err = store.Get(context.TODO(), "consent_user", "expiring_client")
+			So(err, ShouldBeNil)
+			So(c.Scopes, ShouldResemble, []string{"write"})
+			So(c.GrantedAt.Equal(c.UpdatedAt), ShouldBeTrue)
+			So(c.ExpiredAt.IsZero(), ShouldBeTrue)
+
+			err = store.Revoke(context.TODO(), "consent_user", "expiring_client")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Test revoke cascade", func() {
+			err := store.Grant(context.TODO(), "consent_user", "cascade_client", "all", 0)
+			So(err, ShouldBeNil)
+
+			info := &models.Token{
+				ClientID:         "cascade_client",
+				UserID:           "consent_user",
+				Scope:            "all",
+				Access:           "consent_access",
+				AccessCreateAt:   time.Now(),
+				AccessExpiresIn:  time.Minute,
+				Refresh:          "consent_refresh",
+				RefreshCreateAt:  time.Now(),
+				RefreshExpiresIn: time.Hour,
+			}
+			err = tokenStore.Create(context.TODO(), info)
+			So(err, ShouldBeNil)
+
+			err = store.Revoke(context.TODO(), "consent_user", "cascade_client")
+			So(err, ShouldBeNil)
+
+			c, err := store.Get(context.TODO(), "consent_user", "cascade_client")
+			So(err, ShouldBeNil)
+			So(c, ShouldBeNil)
+
+			_, err = tokenStore.GetByAccess(context.TODO(), info.Access)
+			So(err.Error(), ShouldEqual, "mongo: no documents in result")
+
+			_, err = tokenStore.GetByRefresh(context.TODO(), info.Refresh)
+			So(err.Error(), ShouldEqual, "mongo: no documents in result")
+		})
+	})
+}
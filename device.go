@@ -0,0 +1,330 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeviceStatus is the state of a device authorization request
+type DeviceStatus string
+
+const (
+	DeviceStatusPending  DeviceStatus = "pending"
+	DeviceStatusApproved DeviceStatus = "approved"
+	DeviceStatusDenied   DeviceStatus = "denied"
+	DeviceStatusExpired  DeviceStatus = "expired"
+)
+
+const (
+	// userCodeIndex is the unique index of the user codes
+	userCodeIndex = "UserCode_1"
+	// duplicateKeyCode is the code of the error raised by a unique index
+	duplicateKeyCode = 11000
+)
+
+// slowDownIncrement is added to the polling interval on each slow_down answer(RFC 8628 section 3.5)
+const slowDownIncrement = 5 * time.Second
+
+var (
+	// ErrAuthorizationPending the user has not approved or denied the request yet
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown the device polls faster than its interval, the interval has been increased
+	ErrSlowDown = errors.New("slow_down")
+	// ErrAccessDenied the user denied the request
+	ErrAccessDenied = errors.New("access_denied")
+	// ErrExpiredToken the device code has expired
+	ErrExpiredToken = errors.New("expired_token")
+	// ErrUserCodeExists the user code is already used by a pending request, a new one must be generated
+	ErrUserCodeExists = errors.New("user code already exists")
+)
+
+// DeviceCode is a device authorization request(RFC 8628)
+type DeviceCode struct {
+	DeviceCode string       `bson:"_id"`
+	UserCode   string       `bson:"UserCode"`
+	ClientID   string       `bson:"ClientID"`
+	Scope      string       `bson:"Scope,omitempty"`
+	Status     DeviceStatus `bson:"Status"`
+	// UserID is set when the user approves the request
+	UserID string `bson:"UserID,omitempty"`
+	// Interval is the minimum delay between two polls(The default is 5s)
+	Interval     time.Duration `bson:"Interval"`
+	LastPolledAt time.Time     `bson:"LastPolledAt,omitempty"`
+	CreatedAt    time.Time     `bson:"CreatedAt"`
+	ExpiredAt    time.Time     `bson:"ExpiredAt"`
+}
+
+// DeviceConfig device code collection configuration parameters
+type DeviceConfig struct {
+	// store device codes collection name(The default is oauth2_device)
+	DeviceCName string
+}
+
+// NewDefaultDeviceConfig create a default device code configuration
+func NewDefaultDeviceConfig() *DeviceConfig {
+	return &DeviceConfig{
+		DeviceCName: "oauth2_device",
+	}
+}
+
+// DeviceCodeStore MongoDB storage for the device authorization grant
+type DeviceCodeStore struct {
+	dcfg   *DeviceConfig
+	scfg   *StoreConfig
+	client *mongo.Client
+}
+
+// NewDeviceCodeStoreWithSession create a device code store instance based on mongodb
+func NewDeviceCodeStoreWithSession(client *mongo.Client, cfg *Config, dcfg *DeviceConfig, scfgs ...*StoreConfig) *DeviceCodeStore {
+	if dcfg == nil {
+		dcfg = NewDefaultDeviceConfig()
+	}
+
+	ds := &DeviceCodeStore{
+		dcfg:   dcfg,
+		scfg:   NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet),
+		client: client,
+	}
+	ds.scfg.override(scfgs...)
+
+	_, err := ds.c().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "UserCode", Value: 1}},
+			Options: options.Index().SetName(userCodeIndex).SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "ExpiredAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(1),
+		},
+	})
+	if err != nil {
+		ds.scfg.log().Error("Error creating index", "collection", dcfg.DeviceCName, "error", err)
+		panic(err)
+	}
+
+	return ds
+}
+
+func (ds *DeviceCodeStore) c() *mongo.Collection {
+	return ds.client.Database(ds.scfg.db).Collection(ds.dcfg.DeviceCName, ds.scfg.writeOptions(""))
+}
+
+// Create store a new pending device authorization request
+func (ds *DeviceCodeStore) Create(ctx context.Context, dc *DeviceCode) (err error) {
+	ctx, op := ds.scfg.startOperation(ctx, "DeviceCodeStore.Create", "insert", ds.dcfg.DeviceCName)
+	defer func() { op.end(err) }()
+
+	ctxReq, cancel := ds.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	dc.Status = DeviceStatusPending
+	if dc.CreatedAt.IsZero() {
+		dc.CreatedAt = time.Now()
+	}
+	if dc.Interval <= 0 {
+		dc.Interval = 5 * time.Second
+	}
+
+	_, err = ds.c().InsertOne(ctx, dc)
+	if isDuplicateKeyOf(err, userCodeIndex) {
+		err = ErrUserCodeExists
+	}
+	if err != nil {
+		ds.scfg.log().Error("Error DeviceCodeStore.Create", "operation", "insert", "collection", ds.dcfg.DeviceCName, "error", err)
+	}
+	return
+}
+
+// GetByUserCode return the request of the user code entered on the verification page
+// nil is returned for an unknown or expired user code
+func (ds *DeviceCodeStore) GetByUserCode(ctx context.Context, userCode string) (dc *DeviceCode, err error) {
+	ctx, op := ds.scfg.startOperation(ctx, "DeviceCodeStore.GetByUserCode", "find", ds.dcfg.DeviceCName)
+	defer func() { op.endLookup(dc != nil, err) }()
+
+	ctxReq, cancel := ds.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	var d DeviceCode
+	err = ds.c().FindOne(ctx, bson.M{"UserCode": userCode, "ExpiredAt": bson.M{"$gt": time.Now()}}).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Approve grant the pending request of the user code to the user
+// mongo.ErrNoDocuments is returned when no pending request matches
+func (ds *DeviceCodeStore) Approve(ctx context.Context, userCode, userID string) error {
+	return ds.decide(ctx, "DeviceCodeStore.Approve", userCode, bson.M{"Status": DeviceStatusApproved, "UserID": userID})
+}
+
+// Deny reject the pending request of the user code
+// mongo.ErrNoDocuments is returned when no pending request matches
+func (ds *DeviceCodeStore) Deny(ctx context.Context, userCode string) error {
+	return ds.decide(ctx, "DeviceCodeStore.Deny", userCode, bson.M{"Status": DeviceStatusDenied})
+}
+
+// decide update a pending request only, so that a decision can't be changed
+func (ds *DeviceCodeStore) decide(ctx context.Context, name, userCode string, set bson.M) (err error) {
+	ctx, op := ds.scfg.startOperation(ctx, name, "update", ds.dcfg.DeviceCName)
+	defer func() { op.end(err) }()
+
+	ctxReq, cancel := ds.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	res, err := ds.c().UpdateOne(ctx,
+		bson.M{"UserCode": userCode, "Status": DeviceStatusPending, "ExpiredAt": bson.M{"$gt": time.Now()}},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		ds.scfg.log().Error("Error "+name, "operation", "update", "collection", ds.dcfg.DeviceCName, "error", err)
+		return
+	}
+	if res.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return
+}
+
+// Poll answer a device polling the token endpoint
+// the approved request is returned once, and removed, so that a single token is issued
+// ErrAuthorizationPending, ErrSlowDown, ErrAccessDenied or ErrExpiredToken are returned otherwise,
+// ErrExpiredToken also for an unknown device code, as a request removed by the TTL monitor or already redeemed can't be told apart
+func (ds *DeviceCodeStore) Poll(ctx context.Context, deviceCode string) (dc *DeviceCode, err error) {
+	ctx, op := ds.scfg.startOperation(ctx, "DeviceCodeStore.Poll", "update", ds.dcfg.DeviceCName)
+	defer func() {
+		// the polling answers are not store errors
+		if pollAnswer(err) {
+			op.endLookup(false, nil)
+			return
+		}
+		op.endLookup(dc != nil, err)
+	}()
+
+	ctxReq, cancel := ds.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	now := time.Now()
+
+	// record the poll only when the interval has elapsed since the previous one
+	var d DeviceCode
+	err = ds.c().FindOneAndUpdate(ctx,
+		bson.M{
+			"_id": deviceCode,
+			"$or": bson.A{
+				bson.M{"LastPolledAt": bson.M{"$exists": false}},
+				bson.M{"$expr": bson.M{"$lte": bson.A{
+					bson.M{"$add": bson.A{"$LastPolledAt", bson.M{"$divide": bson.A{"$Interval", int64(time.Millisecond)}}}},
+					now,
+				}}},
+			},
+		},
+		bson.M{"$set": bson.M{"LastPolledAt": now}},
+	).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return nil, ds.slowDown(ctx, deviceCode, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if now.After(d.ExpiredAt) || d.Status == DeviceStatusExpired {
+		_, err = ds.c().UpdateOne(ctx, bson.M{"_id": deviceCode}, bson.M{"$set": bson.M{"Status": DeviceStatusExpired}})
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrExpiredToken
+	}
+
+	switch d.Status {
+	case DeviceStatusPending:
+		return nil, ErrAuthorizationPending
+	case DeviceStatusDenied:
+		return nil, ErrAccessDenied
+	}
+
+	// concurrent polls: only the one removing the approved request gets it
+	res, err := ds.c().DeleteOne(ctx, bson.M{"_id": deviceCode, "Status": DeviceStatusApproved})
+	if err != nil {
+		return nil, err
+	}
+	if res.DeletedCount == 0 {
+		return nil, ErrExpiredToken
+	}
+	return &d, nil
+}
+
+func pollAnswer(err error) bool {
+	return err == ErrAuthorizationPending || err == ErrSlowDown || err == ErrAccessDenied || err == ErrExpiredToken
+}
+
+// slowDown increase the interval of a device polling too fast,
+// or answer the state of a request that is no longer pending
+func (ds *DeviceCodeStore) slowDown(ctx context.Context, deviceCode string, now time.Time) error {
+	res, err := ds.c().UpdateOne(ctx,
+		bson.M{
+			"_id":       deviceCode,
+			"Status":    bson.M{"$in": bson.A{DeviceStatusPending, DeviceStatusApproved}},
+			"ExpiredAt": bson.M{"$gt": now},
+		},
+		bson.M{"$inc": bson.M{"Interval": int64(slowDownIncrement)}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return ErrSlowDown
+	}
+
+	var d DeviceCode
+	err = ds.c().FindOne(ctx, bson.M{"_id": deviceCode}).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return ErrExpiredToken
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case now.After(d.ExpiredAt) || d.Status == DeviceStatusExpired:
+		return ErrExpiredToken
+	case d.Status == DeviceStatusDenied:
+		return ErrAccessDenied
+	}
+	// approved meanwhile
+	return ErrSlowDown
+}
+
+// isDuplicateKeyOf tell a duplicate key error raised by the unique index
+func isDuplicateKeyOf(err error, index string) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == duplicateKeyCode && strings.Contains(e.Message, " index: "+index+" ") {
+			return true
+		}
+	}
+	return false
+}
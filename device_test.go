@@ -0,0 +1,128 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIsDuplicateKeyOf(t *testing.T) {
+	Convey("Test duplicate key of an index", t, func() {
+		dup := func(index string) error {
+			return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+				Code:    duplicateKeyCode,
+				Message: "E11000 duplicate key error collection: oauth2.oauth2_device index: " + index + " dup key: { : \"ABCD-EFGH\" }",
+			}}}
+		}
+
+		So(isDuplicateKeyOf(dup(userCodeIndex), userCodeIndex), ShouldBeTrue)
+		So(isDuplicateKeyOf(dup("_id_"), userCodeIndex), ShouldBeFalse)
+		So(isDuplicateKeyOf(mongo.ErrNoDocuments, userCodeIndex), ShouldBeFalse)
+		So(isDuplicateKeyOf(nil, userCodeIndex), ShouldBeFalse)
+	})
+}
+
+func TestDeviceCodeStore(t *testing.T) {
+	Convey("Test mongodb device code store", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		tokenStore := NewTokenStore(cfg)
+		store := NewDeviceCodeStoreWithSession(tokenStore.client, cfg, nil)
+
+		newDeviceCode := func(deviceCode, userCode string) *DeviceCode {
+			return &DeviceCode{
+				DeviceCode: deviceCode,
+				UserCode:   userCode,
+				ClientID:   "tv",
+				Scope:      "all",
+				Interval:   time.Millisecond * 100,
+				ExpiredAt:  time.Now().Add(time.Minute),
+			}
+		}
+
+		Convey("Test approve", func() {
+			dc := newDeviceCode("device_1", "WDJB-MJHT")
+			err := store.Create(context.TODO(), dc)
+			So(err, ShouldBeNil)
+
+			err = store.Create(context.TODO(), newDeviceCode("device_2", "WDJB-MJHT"))
+			So(err, ShouldEqual, ErrUserCodeExists)
+
+			_, err = store.Poll(context.TODO(), dc.DeviceCode)
+			So(err, ShouldEqual, ErrAuthorizationPending)
+
+			// polling faster than the interval
+			_, err = store.Poll(context.TODO(), dc.DeviceCode)
+			So(err, ShouldEqual, ErrSlowDown)
+
+			pending, err := store.GetByUserCode(context.TODO(), dc.UserCode)
+			So(err, ShouldBeNil)
+			So(pending.Interval, ShouldEqual, dc.Interval+slowDownIncrement)
+
+			err = store.Approve(context.TODO(), dc.UserCode, "user_1")
+			So(err, ShouldBeNil)
+
+			err = store.Deny(context.TODO(), dc.UserCode)
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+
+			_, err = store.c().UpdateOne(context.TODO(), bson.M{"_id": dc.DeviceCode}, bson.M{"$set": bson.M{"LastPolledAt": time.Now().Add(-time.Minute)}})
+			So(err, ShouldBeNil)
+
+			approved, err := store.Poll(context.TODO(), dc.DeviceCode)
+			So(err, ShouldBeNil)
+			So(approved.Status, ShouldEqual, DeviceStatusApproved)
+			So(approved.UserID, ShouldEqual, "user_1")
+
+			// the approved request is delivered once
+			approved, err = store.Poll(context.TODO(), dc.DeviceCode)
+			So(err, ShouldEqual, ErrExpiredToken)
+			So(approved, ShouldBeNil)
+
+			_, err = store.Poll(context.TODO(), "unknown")
+			So(err, ShouldEqual, ErrExpiredToken)
+		})
+
+		Convey("Test deny", func() {
+			dc := newDeviceCode("device_3", "BCDF-GHJK")
+			err := store.Create(context.TODO(), dc)
+			So(err, ShouldBeNil)
+
+			err = store.Deny(context.TODO(), dc.UserCode)
+			So(err, ShouldBeNil)
+
+			_, err = store.Poll(context.TODO(), dc.DeviceCode)
+			So(err, ShouldEqual, ErrAccessDenied)
+
+			// polling faster than the interval
+			_, err = store.Poll(context.TODO(), dc.DeviceCode)
+			So(err, ShouldEqual, ErrAccessDenied)
+		})
+
+		Convey("Test expired", func() {
+			dc := newDeviceCode("device_4", "LMNP-QRST")
+			dc.ExpiredAt = time.Now().Add(-time.Second)
+			err := store.Create(context.TODO(), dc)
+			So(err, ShouldBeNil)
+
+			err = store.Approve(context.TODO(), dc.UserCode, "user_1")
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+
+			_, err = store.Poll(context.TODO(), dc.DeviceCode)
+			So(err, ShouldEqual, ErrExpiredToken)
+
+			// polling faster than the interval
+			_, err = store.Poll(context.TODO(), dc.DeviceCode)
+			So(err, ShouldEqual, ErrExpiredToken)
+		})
+	})
+}
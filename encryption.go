@@ -0,0 +1,209 @@
+package mongo
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrUnknownKEK the key-encryption key of a sealed field is not known by the provider
+var ErrUnknownKEK = errors.New("unknown key-encryption key")
+
+// dataKeySize is the size of the AES-256 data keys
+const dataKeySize = 32
+
+// KEKProvider supply the key-encryption keys(KEK) wrapping the data keys of the encrypted fields
+type KEKProvider interface {
+	// ActiveKEK return the id and the key wrapping the new data keys
+	ActiveKEK(ctx context.Context) (id string, kek []byte, err error)
+	// KEK return the key of the id, to unwrap the data keys, ErrUnknownKEK if there is none
+	KEK(ctx context.Context, id string) ([]byte, error)
+}
+
+// LocalKEKProvider is a KEKProvider holding the keys in memory
+type LocalKEKProvider struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewLocalKEKProvider create a provider from the 16, 24 or 32 bytes AES keys by id,
+// activeID wraps the new data keys, the others are kept to read the fields sealed before a rotation
+func NewLocalKEKProvider(activeID string, keys map[string][]byte) *LocalKEKProvider {
+	return &LocalKEKProvider{activeID: activeID, keys: keys}
+}
+
+// ActiveKEK return the id and the key wrapping the new data keys
+func (p *LocalKEKProvider) ActiveKEK(ctx context.Context) (string, []byte, error) {
+	kek, err := p.KEK(ctx, p.activeID)
+	return p.activeID, kek, err
+}
+
+// KEK return the key of the id
+func (p *LocalKEKProvider) KEK(ctx context.Context, id string) ([]byte, error) {
+	kek, ok := p.keys[id]
+	if !ok {
+		return nil, ErrUnknownKEK
+	}
+	return kek, nil
+}
+
+// SetFieldEncryption encrypt the token payloads and the client secrets at rest,
+// each value with its own AES-GCM data key wrapped by the active key of the provider
+func (sc *StoreConfig) SetFieldEncryption(provider KEKProvider) *StoreConfig {
+	sc.kekProvider = provider
+	return sc
+}
+
+// sealedField is an encrypted value with its wrapped data key and the id of the wrapping key
+type sealedField struct {
+	KeyID      string `bson:"KeyID"`
+	DataKey    []byte `bson:"DataKey"`
+	Ciphertext []byte `bson:"Ciphertext"`
+}
+
+// fieldAAD bind a sealed field to its document, a field copied to another document or field does not open
+func fieldAAD(id, field string) []byte {
+	return []byte(id + "\x00" + field)
+}
+
+// seal encrypt the plaintext with a new data key wrapped by the active KEK, aad is the fieldAAD of the field
+func (sc *StoreConfig) seal(ctx context.Context, plaintext, aad []byte) (*sealedField, error) {
+	kid, kek, err := sc.kekProvider.ActiveKEK(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dek := make([]byte, dataKeySize)
+	if _, err = rand.Read(dek); err != nil {
+		return nil, err
+	}
+	ciphertext, err := aesSeal(dek, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := aesSeal(kek, dek, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealedField{KeyID: kid, DataKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// open decrypt the field, rewrapped is not nil when the field was wrapped by a former KEK
+// and holds the data key wrapped by the active one, to store back
+func (sc *StoreConfig) open(ctx context.Context, sf *sealedField, aad []byte) (plaintext []byte, rewrapped *sealedField, err error) {
+	if sc.kekProvider == nil {
+		return nil, nil, ErrUnknownKEK
+	}
+
+	kek, err := sc.kekProvider.KEK(ctx, sf.KeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	dek, err := aesOpen(kek, sf.DataKey, aad)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err = aesOpen(dek, sf.Ciphertext, aad)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kid, active, err := sc.kekProvider.ActiveKEK(ctx)
+	if err != nil || kid == sf.KeyID {
+		// a failing provider must not fail the read of a valid field
+		return plaintext, nil, nil
+	}
+	wrapped, err := aesSeal(active, dek, aad)
+	if err != nil {
+		return plaintext, nil, nil
+	}
+	return plaintext, &sealedField{KeyID: kid, DataKey: wrapped, Ciphertext: sf.Ciphertext}, nil
+}
+
+// openData return the token payload of the document, decrypted if sealed,
+// the document is lazily sealed with the active KEK when it is in clear or wrapped by a former KEK
+func (ts *TokenStore) openData(ctx context.Context, bd *basicData) ([]byte, error) {
+	sc := ts.tcfg.storeConfig
+	if bd.Sealed == nil {
+		if sc.kekProvider != nil && len(bd.Data) > 0 {
+			// stored before the encryption was enabled
+			if sf, err := sc.seal(ctx, bd.Data, fieldAAD(bd.ID, "Sealed")); err == nil {
+				ts.reseal(ctx, bd.ID, bson.M{"Data": bson.M{"$exists": true}}, sf)
+			}
+		}
+		return bd.Data, nil
+	}
+
+	data, rewrapped, err := sc.open(ctx, bd.Sealed, fieldAAD(bd.ID, "Sealed"))
+	if err != nil {
+		sc.log().Error("Error opening sealed token data", "collection", ts.tcfg.BasicCName, "error", err)
+		return nil, err
+	}
+	if rewrapped != nil {
+		ts.reseal(ctx, bd.ID, bson.M{"Sealed.KeyID": bd.Sealed.KeyID}, rewrapped)
+	}
+	return data, nil
+}
+
+// reseal replace the payload of the document by the sealed field, unless it changed in between
+func (ts *TokenStore) reseal(ctx context.Context, basicID string, unchanged bson.M, sf *sealedField) {
+	filter := bson.M{"_id": basicID}
+	for k, v := range unchanged {
+		filter[k] = v
+	}
+	_, err := ts.c(ctx, ts.tcfg.BasicCName, ts.tcfg.storeConfig.writeOptions(WriteTokenCreate)).UpdateOne(ctx, filter, bson.M{
+		"$set":   bson.M{"Sealed": sf},
+		"$unset": bson.M{"Data": ""},
+	})
+	if err != nil {
+		ts.tcfg.storeConfig.log().Error("Error resealing token data", "operation", "update", "collection", ts.tcfg.BasicCName, "error", err)
+	}
+}
+
+// sealSecret return the update setting the client secret, sealed when the encryption is enabled
+func (cs *ClientStore) sealSecret(ctx context.Context, id, secret string) (bson.M, error) {
+	sc := cs.ccfg.storeConfig
+	if sc.kekProvider == nil {
+		return bson.M{"$set": bson.M{"secret": secret}, "$unset": bson.M{"sealedSecret": ""}}, nil
+	}
+	sf, err := sc.seal(ctx, []byte(secret), fieldAAD(id, "sealedSecret"))
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$set": bson.M{"secret": "", "sealedSecret": sf}}, nil
+}
+
+// openSecret return the client secret, decrypted if sealed,
+// the secret is lazily sealed with the active KEK when it is in clear or wrapped by a former KEK
+func (cs *ClientStore) openSecret(ctx context.Context, entity *client) (string, error) {
+	sc := cs.ccfg.storeConfig
+	if entity.SealedSecret == nil {
+		if sc.kekProvider != nil && entity.Secret != "" {
+			// stored before the encryption was enabled
+			if update, err := cs.sealSecret(ctx, entity.ID, entity.Secret); err == nil {
+				cs.reseal(ctx, bson.M{"_id": entity.ID, "secret": entity.Secret}, update)
+			}
+		}
+		return entity.Secret, nil
+	}
+
+	secret, rewrapped, err := sc.open(ctx, entity.SealedSecret, fieldAAD(entity.ID, "sealedSecret"))
+	if err != nil {
+		sc.log().Error("Error opening sealed client secret", "collection", cs.ccfg.ClientsCName, "error", err)
+		return "", err
+	}
+	if rewrapped != nil {
+		cs.reseal(ctx, bson.M{"_id": entity.ID, "sealedSecret.KeyID": entity.SealedSecret.KeyID}, bson.M{"$set": bson.M{"sealedSecret": rewrapped}})
+	}
+	return string(secret), nil
+}
+
+func (cs *ClientStore) reseal(ctx context.Context, filter, update bson.M) {
+	_, err := cs.c(ctx, cs.ccfg.ClientsCName, cs.ccfg.storeConfig.writeOptions(WriteClient)).UpdateOne(ctx, filter, update)
+	if err != nil {
+		cs.ccfg.storeConfig.log().Error("Error resealing client secret", "operation", "update", "collection", cs.ccfg.ClientsCName, "error", err)
+	}
+}
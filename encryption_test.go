@@ -0,0 +1,156 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	kek1 = []byte("0123456789abcdef0123456789abcdef")
+	kek2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestSealedField(t *testing.T) {
+	Convey("Test envelope encryption", t, func() {
+		sc := NewDefaultStoreConfig(dbName, service, false)
+		sc.SetFieldEncryption(NewLocalKEKProvider("k1", map[string][]byte{"k1": kek1}))
+
+		aad := fieldAAD("1", "Sealed")
+		sf, err := sc.seal(context.TODO(), []byte("payload"), aad)
+		So(err, ShouldBeNil)
+		So(sf.KeyID, ShouldEqual, "k1")
+		So(string(sf.Ciphertext), ShouldNotContainSubstring, "payload")
+
+		plaintext, rewrapped, err := sc.open(context.TODO(), sf, aad)
+		So(err, ShouldBeNil)
+		So(string(plaintext), ShouldEqual, "payload")
+		So(rewrapped, ShouldBeNil)
+
+		Convey("Test KEK rotation", func() {
+			sc.SetFieldEncryption(NewLocalKEKProvider("k2", map[string][]byte{"k1": kek1, "k2": kek2}))
+
+			plaintext, rewrapped, err := sc.open(context.TODO(), sf, aad)
+			So(err, ShouldBeNil)
+			So(string(plaintext), ShouldEqual, "payload")
+			So(rewrapped.KeyID, ShouldEqual, "k2")
+			So(rewrapped.Ciphertext, ShouldResemble, sf.Ciphertext)
+
+			plaintext, rewrapped, err = sc.open(context.TODO(), rewrapped, aad)
+			So(err, ShouldBeNil)
+			So(string(plaintext), ShouldEqual, "payload")
+			So(rewrapped, ShouldBeNil)
+		})
+
+		Convey("Test field bound to its document", func() {
+			_, _, err := sc.open(context.TODO(), sf, fieldAAD("2", "Sealed"))
+			So(err, ShouldNotBeNil)
+
+			_, _, err = sc.open(context.TODO(), sf, fieldAAD("1", "sealedSecret"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Test unknown KEK", func() {
+			sc.SetFieldEncryption(NewLocalKEKProvider("k2", map[string][]byte{"k2": kek2}))
+
+			_, _, err := sc.open(context.TODO(), sf, aad)
+			So(err, ShouldEqual, ErrUnknownKEK)
+		})
+	})
+}
+
+func TestFieldEncryption(t *testing.T) {
+	Convey("Test encrypted token payloads and client secrets", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		storeConfig := NewStoreConfig(0, 0).SetFieldEncryption(NewLocalKEKProvider("k1", map[string][]byte{"k1": kek1}))
+		store := NewTokenStore(cfg, storeConfig)
+		clientStore := NewClientStoreWithSession(store.client, cfg, storeConfig)
+
+		code := &models.Token{
+			ClientID:      "1",
+			UserID:        "1_6",
+			Scope:         "all",
+			Code:          "17_17_17",
+			CodeCreateAt:  time.Now(),
+			CodeExpiresIn: time.Second * 5,
+		}
+		err := store.Create(context.TODO(), code)
+		So(err, ShouldBeNil)
+
+		var bd basicData
+		err = store.c(context.TODO(), store.tcfg.BasicCName).FindOne(context.TODO(), bson.M{"_id": code.Code}).Decode(&bd)
+		So(err, ShouldBeNil)
+		So(bd.Data, ShouldBeEmpty)
+		So(bd.Sealed.KeyID, ShouldEqual, "k1")
+
+		cinfo, err := store.GetByCode(context.TODO(), code.Code)
+		So(err, ShouldBeNil)
+		So(cinfo.GetUserID(), ShouldEqual, code.UserID)
+
+		cli := &models.Client{ID: "sealed_client", Secret: "sealed_secret"}
+		_ = clientStore.RemoveByID(cli.ID)
+		err = clientStore.Create(cli)
+		So(err, ShouldBeNil)
+
+		var entity bson.M
+		err = clientStore.c(context.TODO(), clientStore.ccfg.ClientsCName).FindOne(context.TODO(), bson.M{"_id": cli.ID}).Decode(&entity)
+		So(err, ShouldBeNil)
+		So(entity["secret"], ShouldEqual, "")
+
+		// the rotated KEK rewraps the data keys on read
+		rotated := NewLocalKEKProvider("k2", map[string][]byte{"k1": kek1, "k2": kek2})
+		store.tcfg.storeConfig.SetFieldEncryption(rotated)
+		clientStore.ccfg.storeConfig.SetFieldEncryption(rotated)
+
+		cinfo, err = store.GetByCode(context.TODO(), code.Code)
+		So(err, ShouldBeNil)
+		So(cinfo.GetUserID(), ShouldEqual, code.UserID)
+
+		err = store.c(context.TODO(), store.tcfg.BasicCName).FindOne(context.TODO(), bson.M{"_id": code.Code}).Decode(&bd)
+		So(err, ShouldBeNil)
+		So(bd.Sealed.KeyID, ShouldEqual, "k2")
+
+		info, err := clientStore.GetByID(context.TODO(), cli.ID)
+		So(err, ShouldBeNil)
+		So(info.GetSecret(), ShouldEqual, cli.Secret)
+
+		var sealed client
+		err = clientStore.c(context.TODO(), clientStore.ccfg.ClientsCName).FindOne(context.TODO(), bson.M{"_id": cli.ID}).Decode(&sealed)
+		So(err, ShouldBeNil)
+		So(sealed.SealedSecret.KeyID, ShouldEqual, "k2")
+
+		Convey("Test swapped sealed fields", func() {
+			other := &models.Client{ID: "sealed_client_2", Secret: "other_secret"}
+			_ = clientStore.RemoveByID(other.ID)
+			So(clientStore.Create(other), ShouldBeNil)
+
+			// copy the sealed secret of the first client into the second one
+			_, err := clientStore.c(context.TODO(), clientStore.ccfg.ClientsCName).UpdateOne(context.TODO(),
+				bson.M{"_id": other.ID}, bson.M{"$set": bson.M{"sealedSecret": sealed.SealedSecret}})
+			So(err, ShouldBeNil)
+
+			_, err = clientStore.GetByID(context.TODO(), other.ID)
+			So(err, ShouldNotBeNil)
+
+			// copy the sealed payload of the code into another token document
+			swapped := bd
+			swapped.ID = "17_17_18"
+			_, err = store.c(context.TODO(), store.tcfg.BasicCName).InsertOne(context.TODO(), swapped)
+			So(err, ShouldBeNil)
+
+			_, err = store.openData(context.TODO(), &swapped)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
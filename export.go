@@ -0,0 +1,468 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// exportBatchSize is the number of clients read at once by Export
+const exportBatchSize = 100
+
+// record types of an export
+const (
+	recordClient = "client"
+	recordGrant  = "grant"
+)
+
+// ExportOptions select what Export leaves out of the records
+type ExportOptions struct {
+	// ExcludeSecrets export the clients without their secret, such new clients are skipped by Import
+	ExcludeSecrets bool
+	// ExcludeTokenValues export the grants without their tokens, such grants are skipped by Import
+	ExcludeTokenValues bool
+}
+
+// ImportOptions import configuration parameters
+type ImportOptions struct {
+	// Overwrite replace the clients and the grants already stored, they are skipped by default
+	Overwrite bool
+}
+
+// ImportResult is the number of records imported and skipped by Import
+type ImportResult struct {
+	Clients int `json:"clients"`
+	Grants  int `json:"grants"`
+	Skipped int `json:"skipped"`
+}
+
+// exportRecord is a line of an export
+type exportRecord struct {
+	Type   string        `json:"type"`
+	Client *clientRecord `json:"client,omitempty"`
+	Grant  *grantRecord  `json:"grant,omitempty"`
+}
+
+type clientRecord struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret,omitempty"`
+	// the secret was left out by ExcludeSecrets, a client without secret otherwise is public
+	SecretExcluded bool   `json:"secret_excluded,omitempty"`
+	Domain         string `json:"domain"`
+	UserID         string `json:"user_id"`
+}
+
+// grantRecord is a token data with the expiry of its access and refresh tokens,
+// the expiry of a revoked or expired token is nil and its value is cleared from the token information
+type grantRecord struct {
+	ID               string        `json:"id"`
+	Token            *models.Token `json:"token"`
+	ExpiredAt        time.Time     `json:"expired_at"`
+	AccessExpiredAt  *time.Time    `json:"access_expired_at,omitempty"`
+	RefreshExpiredAt *time.Time    `json:"refresh_expired_at,omitempty"`
+}
+
+// restoredToken is an access or refresh token document inserted by Import
+type restoredToken struct {
+	cname string
+	data  tokenData
+}
+
+// Export write the clients of cs, then the active grants of ts, to w as one JSON record per line,
+// either store may be nil. The authorization codes are not exported, they are short-lived
+func Export(ctx context.Context, w io.Writer, cs *ClientStore, ts *TokenStore, opts *ExportOptions) error {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+
+	enc := json.NewEncoder(w)
+	if cs != nil {
+		if err := cs.export(ctx, enc, opts); err != nil {
+			return err
+		}
+	}
+	if ts != nil {
+		return ts.export(ctx, enc, opts)
+	}
+	return nil
+}
+
+// Import store the records written by Export, the clients in cs and the grants in ts,
+// the records of a nil store are skipped. A grant keeps its id, its tokens and their expiry,
+// the tokens expired since the export are left out and a grant left without token is skipped
+func Import(ctx context.Context, r io.Reader, cs *ClientStore, ts *TokenStore, opts *ImportOptions) (*ImportResult, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	res := &ImportResult{}
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var rec exportRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		imported := false
+		switch {
+		case rec.Type == recordClient && rec.Client != nil:
+			if cs != nil {
+				imported, err = cs.importClient(ctx, rec.Client, opts)
+			}
+			if imported {
+				res.Clients++
+			}
+		case rec.Type == recordGrant && rec.Grant != nil:
+			if ts != nil {
+				imported, err = ts.importGrant(ctx, rec.Grant, opts)
+			}
+			if imported {
+				res.Grants++
+			}
+		default:
+			return res, fmt.Errorf("record %d: invalid record of type %q", line, rec.Type)
+		}
+		if err != nil {
+			return res, fmt.Errorf("record %d: %w", line, err)
+		}
+		if !imported {
+			res.Skipped++
+		}
+	}
+}
+
+// export write the clients ordered by id
+func (cs *ClientStore) export(ctx context.Context, enc *json.Encoder, opts *ExportOptions) error {
+	afterID := ""
+	for {
+		infos, err := cs.List(ctx, afterID, exportBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			rec := &clientRecord{ID: info.GetID(), Domain: info.GetDomain(), UserID: info.GetUserID()}
+			if opts.ExcludeSecrets {
+				rec.SecretExcluded = true
+			} else {
+				rec.Secret = info.GetSecret()
+			}
+			if err = enc.Encode(&exportRecord{Type: recordClient, Client: rec}); err != nil {
+				return err
+			}
+			afterID = info.GetID()
+		}
+		if len(infos) < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+// importClient store the client, an existing one is updated when overwriting and keeps its secret if the record has none
+func (cs *ClientStore) importClient(ctx context.Context, rec *clientRecord, opts *ImportOptions) (bool, error) {
+	if rec.ID == "" {
+		return false, errors.New("client without id")
+	}
+
+	info := &models.Client{ID: rec.ID, Secret: rec.Secret, Domain: rec.Domain, UserID: rec.UserID}
+	existing, err := cs.GetByID(ctx, rec.ID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, err
+	}
+	if existing == nil {
+		if rec.SecretExcluded {
+			// created without secret, the client would be public and its secret check skipped
+			return false, nil
+		}
+		return true, cs.CreateWithContext(ctx, info)
+	}
+	if !opts.Overwrite {
+		return false, nil
+	}
+
+	if err = cs.Update(ctx, info); err != nil {
+		return false, err
+	}
+	if rec.Secret != "" {
+		if err = cs.RotateSecret(ctx, rec.ID, rec.Secret); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// export write the token data not expired and holding an access or a refresh token
+// the export is only bounded by ctx, not by the request timeout
+func (ts *TokenStore) export(ctx context.Context, enc *json.Encoder, opts *ExportOptions) (err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.Export", "find", ts.tcfg.BasicCName)
+	defer func() { op.end(err) }()
+
+	if err = ts.tenant(ctx); err != nil {
+		return
+	}
+
+	now := time.Now()
+	cursor, err := ts.c(ctx, ts.tcfg.BasicCName).Find(ctx, bson.M{"ExpiredAt": bson.M{"$gt": now}}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var bd basicData
+		if err = cursor.Decode(&bd); err != nil {
+			return
+		}
+
+		var rec *grantRecord
+		rec, err = ts.grantRecord(ctx, &bd, now)
+		if err != nil {
+			return
+		}
+		if rec == nil {
+			continue
+		}
+		if opts.ExcludeTokenValues {
+			rec.Token.Code = ""
+			rec.Token.Access = ""
+			rec.Token.Refresh = ""
+		}
+		if err = enc.Encode(&exportRecord{Type: recordGrant, Grant: rec}); err != nil {
+			return
+		}
+	}
+	err = cursor.Err()
+	return
+}
+
+// grantRecord read the token information and the expiry of its tokens,
+// nil for an authorization code or a token data whose tokens are all revoked or expired
+func (ts *TokenStore) grantRecord(ctx context.Context, bd *basicData, now time.Time) (*grantRecord, error) {
+	data, err := ts.openData(ctx, bd)
+	if err != nil {
+		return nil, err
+	}
+	var tm models.Token
+	if err = json.Unmarshal(data, &tm); err != nil {
+		return nil, err
+	}
+
+	rec := &grantRecord{ID: bd.ID, Token: &tm, ExpiredAt: bd.ExpiredAt}
+	if rec.AccessExpiredAt, err = ts.tokenExpiry(ctx, ts.tcfg.AccessCName, bd.ID, tm.Access, now); err != nil {
+		return nil, err
+	}
+	if rec.RefreshExpiredAt, err = ts.tokenExpiry(ctx, ts.tcfg.RefreshCName, bd.ID, tm.Refresh, now); err != nil {
+		return nil, err
+	}
+
+	if rec.AccessExpiredAt == nil {
+		tm.Access = ""
+	}
+	if rec.RefreshExpiredAt == nil {
+		tm.Refresh = ""
+	}
+	if tm.Access == "" && tm.Refresh == "" {
+		return nil, nil
+	}
+	return rec, nil
+}
+
+// tokenExpiry return the expiry of the token of the token data, nil when it is revoked or expired
+func (ts *TokenStore) tokenExpiry(ctx context.Context, cname, basicID, token string, now time.Time) (*time.Time, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var td tokenData
+	err := ts.c(ctx, cname).FindOne(ctx, bson.M{"_id": token, "BasicID": basicID}).Decode(&td)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !td.ExpiredAt.After(now) {
+		return nil, nil
+	}
+	return &td.ExpiredAt, nil
+}
+
+// importGrant store the token data and its tokens, the token data sharing its id or one of its tokens
+// are revoked first when overwriting. The middlewares do not run, as for the data restored from a backup
+func (ts *TokenStore) importGrant(ctx context.Context, rec *grantRecord, opts *ImportOptions) (imported bool, err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.Import", "insert", ts.tcfg.BasicCName)
+	defer func() { op.end(err) }()
+
+	if err = ts.tenant(ctx); err != nil {
+		return
+	}
+	if rec.ID == "" || rec.Token == nil {
+		return false, errors.New("grant without id or token")
+	}
+
+	now := time.Now()
+	if !rec.ExpiredAt.After(now) {
+		return false, nil
+	}
+
+	tm := *rec.Token
+	var tokens []restoredToken
+	if tm.Access != "" && rec.AccessExpiredAt != nil && rec.AccessExpiredAt.After(now) {
+		tokens = append(tokens, restoredToken{
+			cname: ts.tcfg.AccessCName,
+			data:  tokenData{ID: tm.Access, BasicID: rec.ID, ExpiredAt: *rec.AccessExpiredAt},
+		})
+	} else {
+		tm.Access = ""
+	}
+	if tm.Refresh != "" && rec.RefreshExpiredAt != nil && rec.RefreshExpiredAt.After(now) {
+		tokens = append(tokens, restoredToken{
+			cname: ts.tcfg.RefreshCName,
+			data:  tokenData{ID: tm.Refresh, BasicID: rec.ID, ExpiredAt: *rec.RefreshExpiredAt},
+		})
+	} else {
+		tm.Refresh = ""
+	}
+	if len(tokens) == 0 {
+		return false, nil
+	}
+
+	conflicts, err := ts.conflicts(ctx, rec.ID, tokens)
+	if err != nil {
+		return
+	}
+	if len(conflicts) > 0 {
+		if !opts.Overwrite {
+			return false, nil
+		}
+		for _, basicID := range conflicts {
+			if err = ts.revokeBasic(ctx, basicID); err != nil {
+				return
+			}
+		}
+	}
+
+	bd := basicData{
+		ID:        rec.ID,
+		ExpiredAt: rec.ExpiredAt,
+		UserID:    tm.UserID,
+		ClientID:  tm.ClientID,
+	}
+	if bd.Data, err = json.Marshal(&tm); err != nil {
+		return
+	}
+	if ts.tcfg.storeConfig.kekProvider != nil {
+		if bd.Sealed, err = ts.tcfg.storeConfig.seal(ctx, bd.Data, fieldAAD(bd.ID, "Sealed")); err != nil {
+			return
+		}
+		bd.Data = nil
+	}
+
+	if err = ts.restore(ctx, bd, tokens); err != nil {
+		ts.tcfg.storeConfig.log().Error("Error importing token data", "operation", "insert", "collection", ts.tcfg.BasicCName, "error", err)
+		return
+	}
+	return true, nil
+}
+
+// conflicts return the ids of the token data stored with the id or one of the tokens of the imported one
+func (ts *TokenStore) conflicts(ctx context.Context, basicID string, tokens []restoredToken) ([]string, error) {
+	var ids []string
+	add := func(id string) {
+		for _, v := range ids {
+			if v == id {
+				return
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	err := ts.c(ctx, ts.tcfg.BasicCName).FindOne(ctx, bson.M{"_id": basicID}).Err()
+	if err == nil {
+		add(basicID)
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	for _, t := range tokens {
+		id, err := ts.getBasicID(ctx, t.cname, t.data.ID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if id != "" {
+			add(id)
+		}
+	}
+	return ids, nil
+}
+
+// restore insert the token data then its tokens, in a transaction for a replicaSet,
+// else the documents inserted before a failure are removed
+func (ts *TokenStore) restore(ctx context.Context, bd basicData, tokens []restoredToken) (err error) {
+	if ts.tcfg.storeConfig.isReplicaSet {
+		wc := ts.tcfg.storeConfig.writeConcern(WriteTokenCreate)
+		if wc == nil {
+			wc = writeconcern.New(writeconcern.WMajority(), writeconcern.WTimeout(2*time.Second))
+		}
+
+		// the transaction joins the causally consistent session started for the context, if any
+		session := causalSession(ctx)
+		if session == nil {
+			session, err = ts.client.StartSession()
+			if err != nil {
+				return
+			}
+			defer session.EndSession(ctx)
+		}
+		_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			_, err := ts.insertGrant(sessCtx, bd, tokens)
+			return nil, err
+		}, options.Transaction().SetWriteConcern(wc))
+		return
+	}
+
+	inserted, err := ts.insertGrant(ctx, bd, tokens)
+	if err == nil || inserted == 0 {
+		return
+	}
+	for _, t := range tokens[:inserted-1] {
+		if _, rbErr := ts.c(ctx, t.cname).DeleteOne(ctx, bson.M{"_id": t.data.ID}); rbErr != nil {
+			// the document expires with its TTL
+			ts.tcfg.storeConfig.log().Error("Error removing imported token", "operation", "delete", "collection", t.cname, "error", rbErr)
+		}
+	}
+	if _, rbErr := ts.c(ctx, ts.tcfg.BasicCName).DeleteOne(ctx, bson.M{"_id": bd.ID}); rbErr != nil {
+		ts.tcfg.storeConfig.log().Error("Error removing imported token data", "operation", "delete", "collection", ts.tcfg.BasicCName, "error", rbErr)
+	}
+	return
+}
+
+// insertGrant insert the token data then its tokens, and return the number of documents inserted
+func (ts *TokenStore) insertGrant(ctx context.Context, bd basicData, tokens []restoredToken) (inserted int, err error) {
+	wopts := ts.tcfg.storeConfig.writeOptions(WriteTokenCreate)
+	if _, err = ts.c(ctx, ts.tcfg.BasicCName, wopts).InsertOne(ctx, bd); err != nil {
+		return
+	}
+	inserted++
+
+	for _, t := range tokens {
+		if _, err = ts.c(ctx, t.cname, wopts).InsertOne(ctx, t.data); err != nil {
+			return
+		}
+		inserted++
+	}
+	return
+}
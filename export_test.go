@@ -0,0 +1,189 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/manage"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestImportRecords(t *testing.T) {
+	Convey("Test import records", t, func() {
+		input := `{"type":"client","client":{"id":"app","secret":"s","domain":"http://localhost","user_id":"u"}}
+{"type":"grant","grant":{"id":"g","token":{"Access":"a"},"expired_at":"2100-01-01T00:00:00Z"}}
+`
+		res, err := Import(context.TODO(), strings.NewReader(input), nil, nil, nil)
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, &ImportResult{Skipped: 2})
+
+		_, err = Import(context.TODO(), strings.NewReader(`{"type":"consent"}`), nil, nil, nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "record 1")
+
+		_, err = Import(context.TODO(), strings.NewReader(input+"{"), nil, nil, nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "record 3")
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	Convey("Test mongodb export and import", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+		tokenStore := NewTokenStore(cfg)
+		clientStore := NewClientStore(cfg)
+		ctx := context.TODO()
+
+		cli := &models.Client{ID: "export_client", Secret: "export_secret", Domain: "http://localhost", UserID: "export_user"}
+		So(clientStore.CreateWithContext(ctx, cli), ShouldBeNil)
+
+		info := &models.Token{
+			ClientID:         cli.ID,
+			UserID:           cli.UserID,
+			Scope:            "all",
+			Access:           "export_access",
+			AccessCreateAt:   time.Now(),
+			AccessExpiresIn:  time.Minute,
+			Refresh:          "export_refresh",
+			RefreshCreateAt:  time.Now(),
+			RefreshExpiresIn: time.Hour,
+		}
+		So(tokenStore.Create(ctx, info), ShouldBeNil)
+
+		var td tokenData
+		err := tokenStore.c(ctx, tokenStore.tcfg.AccessCName).FindOne(ctx, bson.M{"_id": info.Access}).Decode(&td)
+		So(err, ShouldBeNil)
+
+		var buf bytes.Buffer
+		So(Export(ctx, &buf, clientStore, tokenStore, nil), ShouldBeNil)
+		So(buf.String(), ShouldContainSubstring, `"secret":"export_secret"`)
+		So(buf.String(), ShouldContainSubstring, td.BasicID)
+		export := buf.String()
+
+		Convey("Test exclusions", func() {
+			buf.Reset()
+			So(Export(ctx, &buf, clientStore, tokenStore, &ExportOptions{ExcludeSecrets: true, ExcludeTokenValues: true}), ShouldBeNil)
+			So(buf.String(), ShouldNotContainSubstring, "export_secret")
+			So(buf.String(), ShouldNotContainSubstring, "export_access")
+			So(buf.String(), ShouldContainSubstring, td.BasicID)
+		})
+
+		Convey("Test restore", func() {
+			So(tokenStore.RevokeByRefresh(ctx, info.Refresh), ShouldBeNil)
+			So(clientStore.RemoveByIDWithContext(ctx, cli.ID), ShouldBeNil)
+
+			res, err := Import(ctx, strings.NewReader(export), clientStore, tokenStore, nil)
+			So(err, ShouldBeNil)
+			So(res.Clients, ShouldBeGreaterThanOrEqualTo, 1)
+			So(res.Grants, ShouldBeGreaterThanOrEqualTo, 1)
+
+			cinfo, err := clientStore.GetByID(ctx, cli.ID)
+			So(err, ShouldBeNil)
+			So(cinfo.GetSecret(), ShouldEqual, cli.Secret)
+
+			ainfo, err := tokenStore.GetByAccess(ctx, info.Access)
+			So(err, ShouldBeNil)
+			So(ainfo.GetRefresh(), ShouldEqual, info.Refresh)
+
+			var restored tokenData
+			err = tokenStore.c(ctx, tokenStore.tcfg.AccessCName).FindOne(ctx, bson.M{"_id": info.Access}).Decode(&restored)
+			So(err, ShouldBeNil)
+			So(restored.BasicID, ShouldEqual, td.BasicID)
+			So(restored.ExpiredAt.Equal(td.ExpiredAt), ShouldBeTrue)
+
+			rinfo, err := tokenStore.GetByRefresh(ctx, info.Refresh)
+			So(err, ShouldBeNil)
+			So(rinfo.GetAccess(), ShouldEqual, info.Access)
+
+			Convey("Test conflicts", func() {
+				res, err := Import(ctx, strings.NewReader(export), clientStore, tokenStore, nil)
+				So(err, ShouldBeNil)
+				So(res.Clients+res.Grants, ShouldEqual, 0)
+
+				res, err = Import(ctx, strings.NewReader(export), clientStore, tokenStore, &ImportOptions{Overwrite: true})
+				So(err, ShouldBeNil)
+				So(res.Clients, ShouldBeGreaterThanOrEqualTo, 1)
+				So(res.Grants, ShouldBeGreaterThanOrEqualTo, 1)
+
+				ainfo, err := tokenStore.GetByAccess(ctx, info.Access)
+				So(err, ShouldBeNil)
+				So(ainfo, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Test clients without secret", func() {
+			buf.Reset()
+			So(Export(ctx, &buf, clientStore, nil, &ExportOptions{ExcludeSecrets: true}), ShouldBeNil)
+			So(clientStore.RemoveByIDWithContext(ctx, cli.ID), ShouldBeNil)
+
+			res, err := Import(ctx, strings.NewReader(buf.String()), clientStore, nil, nil)
+			So(err, ShouldBeNil)
+			So(res.Skipped, ShouldBeGreaterThanOrEqualTo, 1)
+
+			_, err = clientStore.GetByID(ctx, cli.ID)
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+
+			// the client cannot authenticate with an empty secret
+			manager := manage.NewDefaultManager()
+			manager.MapClientStorage(clientStore)
+			manager.MapTokenStorage(tokenStore)
+			_, err = manager.GenerateAccessToken(ctx, oauth2.ClientCredentials, &oauth2.TokenGenerateRequest{ClientID: cli.ID})
+			So(err, ShouldNotBeNil)
+
+			// an existing client keeps its secret
+			So(clientStore.CreateWithContext(ctx, cli), ShouldBeNil)
+			res, err = Import(ctx, strings.NewReader(buf.String()), clientStore, nil, &ImportOptions{Overwrite: true})
+			So(err, ShouldBeNil)
+			So(res.Clients, ShouldBeGreaterThanOrEqualTo, 1)
+
+			cinfo, err := clientStore.GetByID(ctx, cli.ID)
+			So(err, ShouldBeNil)
+			So(cinfo.GetSecret(), ShouldEqual, cli.Secret)
+		})
+
+		Convey("Test public clients", func() {
+			public := &models.Client{ID: "export_public", Domain: "http://localhost", UserID: "export_user"}
+			So(clientStore.CreateWithContext(ctx, public), ShouldBeNil)
+			defer clientStore.RemoveByIDWithContext(ctx, public.ID)
+
+			buf.Reset()
+			So(Export(ctx, &buf, clientStore, nil, nil), ShouldBeNil)
+			So(buf.String(), ShouldNotContainSubstring, "secret_excluded")
+			So(clientStore.RemoveByIDWithContext(ctx, public.ID), ShouldBeNil)
+
+			_, err := Import(ctx, strings.NewReader(buf.String()), clientStore, nil, nil)
+			So(err, ShouldBeNil)
+
+			cinfo, err := clientStore.GetByID(ctx, public.ID)
+			So(err, ShouldBeNil)
+			So(cinfo.GetSecret(), ShouldEqual, "")
+		})
+
+		Convey("Test revoked access", func() {
+			So(tokenStore.RemoveByAccess(ctx, info.Access), ShouldBeNil)
+
+			buf.Reset()
+			So(Export(ctx, &buf, nil, tokenStore, nil), ShouldBeNil)
+			So(buf.String(), ShouldNotContainSubstring, "export_access")
+			So(buf.String(), ShouldContainSubstring, "export_refresh")
+		})
+
+		Reset(func() {
+			tokenStore.RevokeByRefresh(ctx, info.Refresh)
+			clientStore.RemoveByIDWithContext(ctx, cli.ID)
+		})
+	})
+}
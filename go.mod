@@ -4,6 +4,11 @@ go 1.13
 
 require (
 	github.com/go-oauth2/oauth2/v4 v4.5.2
+	github.com/golang-jwt/jwt v3.2.1+incompatible
+	github.com/prometheus/client_golang v1.19.0
 	github.com/smartystreets/goconvey v1.6.4
 	go.mongodb.org/mongo-driver v1.12.0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
 )
@@ -0,0 +1,164 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// HealthStatusUp the check succeeded
+	HealthStatusUp = "up"
+	// HealthStatusDown the check failed
+	HealthStatusDown = "down"
+
+	// defaultTxnBacklogLimit is the number of pending oauth2_txn entries above which the TokenStore is reported down
+	defaultTxnBacklogLimit = 1000
+
+	// namespaceNotFoundCode is returned by listIndexes when the collection does not exist yet
+	namespaceNotFoundCode = 26
+)
+
+// HealthCheck is the result of a single check
+type HealthCheck struct {
+	Name    string      `json:"name"`
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// HealthStatus is the aggregated result of all the checks of a store
+type HealthStatus struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// IsUp report whether every check succeeded
+func (hs *HealthStatus) IsUp() bool {
+	return hs.Status == HealthStatusUp
+}
+
+func (hs *HealthStatus) add(name string, err error, details interface{}) {
+	check := HealthCheck{
+		Name:    name,
+		Status:  HealthStatusUp,
+		Details: details,
+	}
+	if err != nil {
+		check.Status = HealthStatusDown
+		check.Error = err.Error()
+		hs.Status = HealthStatusDown
+	}
+	hs.Checks = append(hs.Checks, check)
+}
+
+// HealthChecker is implemented by TokenStore and ClientStore
+type HealthChecker interface {
+	Health(ctx context.Context) *HealthStatus
+}
+
+// checkConnectivity ping the db
+func checkConnectivity(ctx context.Context, client *mongo.Client) error {
+	return client.Ping(ctx, nil)
+}
+
+// checkPrimary verify a replicaSet primary is elected and reachable, or that the node is a mongos
+func checkPrimary(ctx context.Context, client *mongo.Client, db string) (string, error) {
+	var res struct {
+		IsMaster bool   `bson:"ismaster"`
+		SetName  string `bson:"setName"`
+		Primary  string `bson:"primary"`
+		Msg      string `bson:"msg"`
+	}
+	err := client.Database(db).RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&res)
+	if err != nil {
+		return "", err
+	}
+	if res.Msg == "isdbgrid" {
+		// a mongos routes to the primaries of the shards
+		return "mongos", nil
+	}
+	if res.SetName == "" {
+		return "", fmt.Errorf("node is not a replicaSet member")
+	}
+	if res.Primary == "" {
+		return "", fmt.Errorf("no primary available for replicaSet %s", res.SetName)
+	}
+	return res.Primary, nil
+}
+
+// checkIndex verify an index exists on the given key of the collection
+// if ttl is true, the index must also be a TTL index
+func checkIndex(ctx context.Context, coll *mongo.Collection, key string, ttl, unique bool) error {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		// the _id index is created along with the collection on the first insert
+		var cmdErr mongo.CommandError
+		if key == "_id" && errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFoundCode {
+			return nil
+		}
+		return err
+	}
+
+	var indexes []struct {
+		Key                bson.D      `bson:"key"`
+		Unique             bool        `bson:"unique"`
+		ExpireAfterSeconds interface{} `bson:"expireAfterSeconds"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		if len(idx.Key) != 1 || idx.Key[0].Key != key {
+			continue
+		}
+		if ttl && idx.ExpireAfterSeconds == nil {
+			continue
+		}
+		// _id index is always unique, even though the flag is not set
+		if unique && key != "_id" && !idx.Unique {
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("missing index on %s.%s", coll.Name(), key)
+}
+
+// NewHealthHandler create an http.Handler suitable for readiness/liveness probes
+// it responds 200 when every store is up, 503 otherwise, with the checks as a JSON body
+func NewHealthHandler(timeout time.Duration, checkers ...HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		result := &HealthStatus{Status: HealthStatusUp}
+		for _, checker := range checkers {
+			hs := checker.Health(ctx)
+			if !hs.IsUp() {
+				result.Status = HealthStatusDown
+			}
+			result.Checks = append(result.Checks, hs.Checks...)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if result.IsUp() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	})
+}
@@ -0,0 +1,55 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type mockHealthChecker struct {
+	err error
+}
+
+func (mh *mockHealthChecker) Health(ctx context.Context) *HealthStatus {
+	hs := &HealthStatus{Status: HealthStatusUp}
+	hs.add("mock", mh.err, nil)
+	return hs
+}
+
+func TestHealthHandler(t *testing.T) {
+	Convey("Test health handler", t, func() {
+		Convey("AllUp", func() {
+			handler := NewHealthHandler(0, &mockHealthChecker{}, &mockHealthChecker{})
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+
+			var hs HealthStatus
+			So(json.Unmarshal(rec.Body.Bytes(), &hs), ShouldBeNil)
+			So(hs.Status, ShouldEqual, HealthStatusUp)
+			So(len(hs.Checks), ShouldEqual, 2)
+		})
+
+		Convey("OneDown", func() {
+			handler := NewHealthHandler(0, &mockHealthChecker{}, &mockHealthChecker{err: errors.New("no primary")})
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+
+			var hs HealthStatus
+			So(json.Unmarshal(rec.Body.Bytes(), &hs), ShouldBeNil)
+			So(hs.Status, ShouldEqual, HealthStatusDown)
+			So(hs.Checks[1].Error, ShouldEqual, "no primary")
+		})
+	})
+}
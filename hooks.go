@@ -0,0 +1,102 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// TokenOperation is the TokenStore method intercepted by the middlewares
+type TokenOperation string
+
+const (
+	TokenCreate          TokenOperation = "Create"
+	TokenGetByCode       TokenOperation = "GetByCode"
+	TokenGetByAccess     TokenOperation = "GetByAccess"
+	TokenGetByRefresh    TokenOperation = "GetByRefresh"
+	TokenRemoveByCode    TokenOperation = "RemoveByCode"
+	TokenRemoveByAccess  TokenOperation = "RemoveByAccess"
+	TokenRemoveByRefresh TokenOperation = "RemoveByRefresh"
+)
+
+// TokenCall is an intercepted TokenStore call
+type TokenCall struct {
+	Operation TokenOperation
+	// Token is the code, access or refresh token of the GetBy* and RemoveBy* calls
+	Token string
+	// Info is the token information to store for Create, the result for GetBy*
+	Info oauth2.TokenInfo
+}
+
+// TokenHandler execute a TokenStore call
+type TokenHandler func(ctx context.Context, call *TokenCall) error
+
+// TokenMiddleware wrap a TokenHandler, it can veto the call by returning an error without calling next,
+// mutate the call before or after next, or observe it
+type TokenMiddleware func(next TokenHandler) TokenHandler
+
+// UseTokenMiddlewares append middlewares run around the TokenStore methods,
+// the first one is the outermost
+func (sc *StoreConfig) UseTokenMiddlewares(mws ...TokenMiddleware) *StoreConfig {
+	// copy the chain, its backing array may be shared by the configurations copied by override
+	sc.tokenMiddlewares = append(append([]TokenMiddleware(nil), sc.tokenMiddlewares...), mws...)
+	return sc
+}
+
+// intercept run the call through the middlewares chain down to h
+func (ts *TokenStore) intercept(ctx context.Context, call *TokenCall, h TokenHandler) error {
+	if err := ts.tenant(ctx); err != nil {
+		return err
+	}
+
+	mws := ts.tcfg.storeConfig.tokenMiddlewares
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return ts.causal(ctx, func(ctx context.Context) error {
+		return h(ctx, call)
+	})
+}
+
+// TokenHook build a TokenMiddleware from optional before and after functions
+type TokenHook struct {
+	// Operations the hook apply to, all of them when empty
+	Operations []TokenOperation
+	// Before is run before the call, returning an error vetoes it
+	Before func(ctx context.Context, call *TokenCall) error
+	// After is run after the call with its error, the returned error replaces it
+	After func(ctx context.Context, call *TokenCall, err error) error
+}
+
+func (h TokenHook) match(op TokenOperation) bool {
+	if len(h.Operations) == 0 {
+		return true
+	}
+	for _, o := range h.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware return the hook as a TokenMiddleware
+func (h TokenHook) Middleware() TokenMiddleware {
+	return func(next TokenHandler) TokenHandler {
+		return func(ctx context.Context, call *TokenCall) error {
+			if !h.match(call.Operation) {
+				return next(ctx, call)
+			}
+			if h.Before != nil {
+				if err := h.Before(ctx, call); err != nil {
+					return err
+				}
+			}
+			err := next(ctx, call)
+			if h.After != nil {
+				err = h.After(ctx, call, err)
+			}
+			return err
+		}
+	}
+}
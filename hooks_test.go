@@ -0,0 +1,115 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenMiddlewares(t *testing.T) {
+	Convey("Test token middlewares", t, func() {
+		calls := []string{}
+		trace := func(name string) TokenMiddleware {
+			return func(next TokenHandler) TokenHandler {
+				return func(ctx context.Context, call *TokenCall) error {
+					calls = append(calls, name+".before")
+					err := next(ctx, call)
+					calls = append(calls, name+".after")
+					return err
+				}
+			}
+		}
+		store := func(handler TokenHandler) TokenHandler {
+			return func(ctx context.Context, call *TokenCall) error {
+				calls = append(calls, "store")
+				return handler(ctx, call)
+			}
+		}
+		noop := func(ctx context.Context, call *TokenCall) error { return nil }
+
+		Convey("Shared chain", func() {
+			base := NewStoreConfig(0, 0)
+			base.tokenMiddlewares = make([]TokenMiddleware, 0, 4)
+			base.UseTokenMiddlewares(trace("shared"))
+
+			first := NewStoreConfig(0, 0)
+			first.override(base)
+			first.UseTokenMiddlewares(trace("first"))
+			second := NewStoreConfig(0, 0)
+			second.override(base)
+			second.UseTokenMiddlewares(trace("second"))
+
+			ts := &TokenStore{tcfg: NewDefaultTokenConfig(first)}
+			So(ts.intercept(context.TODO(), &TokenCall{Operation: TokenCreate}, noop), ShouldBeNil)
+			So(calls, ShouldResemble, []string{"shared.before", "first.before", "first.after", "shared.after"})
+		})
+
+		Convey("Order", func() {
+			ts := &TokenStore{tcfg: NewDefaultTokenConfig(NewStoreConfig(0, 0).UseTokenMiddlewares(trace("first"), trace("second")))}
+
+			err := ts.intercept(context.TODO(), &TokenCall{Operation: TokenCreate}, store(noop))
+
+			So(err, ShouldBeNil)
+			So(calls, ShouldResemble, []string{"first.before", "second.before", "store", "second.after", "first.after"})
+		})
+
+		Convey("Veto", func() {
+			disabled := errors.New("user disabled")
+			hook := TokenHook{
+				Operations: []TokenOperation{TokenCreate},
+				Before: func(ctx context.Context, call *TokenCall) error {
+					if call.Info.GetUserID() == "disabled" {
+						return disabled
+					}
+					return nil
+				},
+			}
+			ts := &TokenStore{tcfg: NewDefaultTokenConfig(NewStoreConfig(0, 0).UseTokenMiddlewares(hook.Middleware()))}
+
+			err := ts.intercept(context.TODO(), &TokenCall{Operation: TokenCreate, Info: &models.Token{UserID: "disabled"}}, store(noop))
+
+			So(err, ShouldEqual, disabled)
+			So(len(calls), ShouldEqual, 0)
+		})
+
+		Convey("MutateResult", func() {
+			hook := TokenHook{
+				Operations: []TokenOperation{TokenGetByAccess},
+				After: func(ctx context.Context, call *TokenCall, err error) error {
+					if err == nil {
+						call.Info.SetScope(call.Info.GetScope() + " enriched")
+					}
+					return err
+				},
+			}
+			ts := &TokenStore{tcfg: NewDefaultTokenConfig(NewStoreConfig(0, 0).UseTokenMiddlewares(hook.Middleware()))}
+
+			call := &TokenCall{Operation: TokenGetByAccess, Token: "access"}
+			err := ts.intercept(context.TODO(), call, func(ctx context.Context, call *TokenCall) error {
+				call.Info = &models.Token{Scope: "all"}
+				return nil
+			})
+
+			So(err, ShouldBeNil)
+			So(call.Info.GetScope(), ShouldEqual, "all enriched")
+		})
+
+		Convey("SkipOtherOperations", func() {
+			hook := TokenHook{
+				Operations: []TokenOperation{TokenCreate},
+				Before: func(ctx context.Context, call *TokenCall) error {
+					return errors.New("should not run")
+				},
+			}
+			ts := &TokenStore{tcfg: NewDefaultTokenConfig(NewStoreConfig(0, 0).UseTokenMiddlewares(hook.Middleware()))}
+
+			err := ts.intercept(context.TODO(), &TokenCall{Operation: TokenRemoveByAccess}, store(noop))
+
+			So(err, ShouldBeNil)
+			So(calls, ShouldResemble, []string{"store"})
+		})
+	})
+}
@@ -0,0 +1,191 @@
+package mongo
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	tokenTypeHintAccess  = "access_token"
+	tokenTypeHintRefresh = "refresh_token"
+)
+
+var errInvalidClient = errors.New("invalid_client")
+
+// IntrospectionResponse is the RFC 7662 introspection response
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+}
+
+// endpointError is the RFC 6749 error response
+type endpointError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// IntrospectionHandler implements the RFC 7662 token introspection endpoint
+// the calling resource server authenticates as a client of the ClientStore
+type IntrospectionHandler struct {
+	tokenStore  oauth2.TokenStore
+	clientStore oauth2.ClientStore
+}
+
+// NewIntrospectionHandler create the introspection endpoint handler
+func NewIntrospectionHandler(ts oauth2.TokenStore, cs oauth2.ClientStore) *IntrospectionHandler {
+	return &IntrospectionHandler{
+		tokenStore:  ts,
+		clientStore: cs,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (ih *IntrospectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, endpointError{Error: "invalid_request"})
+		return
+	}
+
+	if _, err := authenticateClient(r, ih.clientStore); err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeJSON(w, http.StatusBadRequest, endpointError{Error: "invalid_request", ErrorDescription: "missing token"})
+		return
+	}
+
+	resp, err := ih.Introspect(r.Context(), token, r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, endpointError{Error: "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Introspect lookup the token, following the hint first, and describe it
+func (ih *IntrospectionHandler) Introspect(ctx context.Context, token, hint string) (*IntrospectionResponse, error) {
+	ti, tokenType, err := lookupToken(ctx, ih.tokenStore, token, hint)
+	if err != nil {
+		return nil, err
+	}
+	if ti == nil {
+		return &IntrospectionResponse{Active: false}, nil
+	}
+
+	var createAt time.Time
+	var expiresIn time.Duration
+	if tokenType == tokenTypeHintAccess {
+		createAt, expiresIn = ti.GetAccessCreateAt(), ti.GetAccessExpiresIn()
+	} else {
+		createAt, expiresIn = ti.GetRefreshCreateAt(), ti.GetRefreshExpiresIn()
+	}
+
+	resp := &IntrospectionResponse{
+		Active:   true,
+		Scope:    ti.GetScope(),
+		ClientID: ti.GetClientID(),
+		Username: ti.GetUserID(),
+		Iat:      createAt.Unix(),
+	}
+	if tokenType == tokenTypeHintAccess {
+		resp.TokenType = "Bearer"
+	} else {
+		resp.TokenType = tokenTypeHintRefresh
+	}
+	if expiresIn > 0 {
+		exp := createAt.Add(expiresIn)
+		if time.Now().After(exp) {
+			return &IntrospectionResponse{Active: false}, nil
+		}
+		resp.Exp = exp.Unix()
+	}
+	return resp, nil
+}
+
+// lookupToken search the token as an access then a refresh token, or the other way round if hinted
+// a nil TokenInfo is returned when the token is unknown
+func lookupToken(ctx context.Context, ts oauth2.TokenStore, token, hint string) (oauth2.TokenInfo, string, error) {
+	order := []string{tokenTypeHintAccess, tokenTypeHintRefresh}
+	if hint == tokenTypeHintRefresh {
+		order = []string{tokenTypeHintRefresh, tokenTypeHintAccess}
+	}
+
+	for _, tokenType := range order {
+		var ti oauth2.TokenInfo
+		var err error
+		if tokenType == tokenTypeHintAccess {
+			ti, err = ts.GetByAccess(ctx, token)
+		} else {
+			ti, err = ts.GetByRefresh(ctx, token)
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, "", err
+		}
+		if err == nil && ti != nil {
+			return ti, tokenType, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// authenticateClient authenticate the caller with HTTP Basic or the client_id and client_secret form parameters
+func authenticateClient(r *http.Request, cs oauth2.ClientStore) (oauth2.ClientInfo, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID == "" || secret == "" {
+		return nil, errInvalidClient
+	}
+
+	cli, err := cs.GetByID(r.Context(), clientID)
+	if err != nil || cli == nil {
+		return nil, errInvalidClient
+	}
+
+	if verifier, ok := cli.(oauth2.ClientPasswordVerifier); ok {
+		if !verifier.VerifyPassword(secret) {
+			return nil, errInvalidClient
+		}
+	} else if subtle.ConstantTimeCompare([]byte(cli.GetSecret()), []byte(secret)) != 1 {
+		return nil, errInvalidClient
+	}
+	return cli, nil
+}
+
+func writeClientError(w http.ResponseWriter, err error) {
+	if err == errInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+		writeJSON(w, http.StatusUnauthorized, endpointError{Error: "invalid_client"})
+		return
+	}
+	writeJSON(w, http.StatusBadRequest, endpointError{Error: "invalid_request"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
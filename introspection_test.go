@@ -0,0 +1,161 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mockTokenStore is an in memory oauth2.TokenStore
+type mockTokenStore struct {
+	access  map[string]oauth2.TokenInfo
+	refresh map[string]oauth2.TokenInfo
+}
+
+func newMockTokenStore(infos ...oauth2.TokenInfo) *mockTokenStore {
+	ms := &mockTokenStore{access: map[string]oauth2.TokenInfo{}, refresh: map[string]oauth2.TokenInfo{}}
+	for _, info := range infos {
+		_ = ms.Create(context.TODO(), info)
+	}
+	return ms
+}
+
+func (ms *mockTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	if info.GetAccess() != "" {
+		ms.access[info.GetAccess()] = info
+	}
+	if info.GetRefresh() != "" {
+		ms.refresh[info.GetRefresh()] = info
+	}
+	return nil
+}
+
+func (ms *mockTokenStore) RemoveByCode(ctx context.Context, code string) error { return nil }
+
+func (ms *mockTokenStore) RemoveByAccess(ctx context.Context, access string) error {
+	delete(ms.access, access)
+	return nil
+}
+
+func (ms *mockTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	delete(ms.refresh, refresh)
+	return nil
+}
+
+func (ms *mockTokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return nil, nil
+}
+
+func (ms *mockTokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	if ti, ok := ms.access[access]; ok {
+		return ti, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (ms *mockTokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	if ti, ok := ms.refresh[refresh]; ok {
+		return ti, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// mockClientStore is an in memory oauth2.ClientStore
+type mockClientStore map[string]oauth2.ClientInfo
+
+func (mc mockClientStore) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	if cli, ok := mc[id]; ok {
+		return cli, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func postForm(handler http.Handler, form neturl.Values, clientID, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIntrospectionHandler(t *testing.T) {
+	Convey("Test introspection handler", t, func() {
+		info := &models.Token{
+			ClientID:         "app",
+			UserID:           "user",
+			Scope:            "read",
+			Access:           "access_token",
+			AccessCreateAt:   time.Now(),
+			AccessExpiresIn:  time.Hour,
+			Refresh:          "refresh_token",
+			RefreshCreateAt:  time.Now(),
+			RefreshExpiresIn: 2 * time.Hour,
+		}
+		expired := &models.Token{
+			ClientID:        "app",
+			Access:          "expired_token",
+			AccessCreateAt:  time.Now().Add(-2 * time.Hour),
+			AccessExpiresIn: time.Hour,
+		}
+		clients := mockClientStore{"rs": &models.Client{ID: "rs", Secret: "rs_secret"}}
+		handler := NewIntrospectionHandler(newMockTokenStore(info, expired), clients)
+
+		Convey("ActiveAccessToken", func() {
+			rec := postForm(handler, neturl.Values{"token": {"access_token"}}, "rs", "rs_secret")
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var resp IntrospectionResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Active, ShouldBeTrue)
+			So(resp.ClientID, ShouldEqual, "app")
+			So(resp.Username, ShouldEqual, "user")
+			So(resp.Scope, ShouldEqual, "read")
+			So(resp.TokenType, ShouldEqual, "Bearer")
+			So(resp.Exp, ShouldEqual, info.AccessCreateAt.Add(time.Hour).Unix())
+		})
+
+		Convey("RefreshTokenWithHint", func() {
+			rec := postForm(handler, neturl.Values{"token": {"refresh_token"}, "token_type_hint": {"refresh_token"}}, "rs", "rs_secret")
+
+			var resp IntrospectionResponse
+			So(json.Unmarshal(rec.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Active, ShouldBeTrue)
+			So(resp.TokenType, ShouldEqual, "refresh_token")
+		})
+
+		Convey("ExpiredOrUnknownToken", func() {
+			for _, token := range []string{"expired_token", "unknown"} {
+				rec := postForm(handler, neturl.Values{"token": {token}}, "rs", "rs_secret")
+
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(strings.TrimSpace(rec.Body.String()), ShouldEqual, `{"active":false}`)
+			}
+		})
+
+		Convey("InvalidClient", func() {
+			rec := postForm(handler, neturl.Values{"token": {"access_token"}}, "rs", "wrong")
+
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+			So(rec.Header().Get("WWW-Authenticate"), ShouldNotBeEmpty)
+		})
+
+		Convey("MissingToken", func() {
+			rec := postForm(handler, neturl.Values{}, "rs", "rs_secret")
+
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
@@ -0,0 +1,550 @@
+package mongo
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/generates"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// KeyState is the rotation state of a signing key
+type KeyState string
+
+const (
+	// KeyStateNext the key is published but does not sign yet
+	KeyStateNext KeyState = "next"
+	// KeyStateActive the key signs the new tokens
+	KeyStateActive KeyState = "active"
+	// KeyStateRetired the key is published until the tokens it signed have expired
+	KeyStateRetired KeyState = "retired"
+)
+
+const (
+	// activeKeyCacheTTL is how long ActiveKey keeps the active key in memory
+	activeKeyCacheTTL = time.Minute
+	// rotationLockTimeout is after how long an interrupted rotation can be taken over
+	rotationLockTimeout = time.Minute
+	// keySlotIndex is the unique index holding at most one active and one next key
+	keySlotIndex = "Slot_1"
+)
+
+var (
+	// ErrNoActiveKey there is no active signing key
+	ErrNoActiveKey = errors.New("no active signing key")
+	// ErrKeyEncrypted the private key is encrypted and no KeyConfig.EncryptionKey is set
+	ErrKeyEncrypted = errors.New("the private key is encrypted, set KeyConfig.EncryptionKey")
+)
+
+// JWK is the public part of a signing key(RFC 7517)
+type JWK struct {
+	Kty string `bson:"kty" json:"kty"`
+	Use string `bson:"use,omitempty" json:"use,omitempty"`
+	Kid string `bson:"kid" json:"kid"`
+	Alg string `bson:"alg,omitempty" json:"alg,omitempty"`
+	// RSA
+	N string `bson:"n,omitempty" json:"n,omitempty"`
+	E string `bson:"e,omitempty" json:"e,omitempty"`
+	// EC
+	Crv string `bson:"crv,omitempty" json:"crv,omitempty"`
+	X   string `bson:"x,omitempty" json:"x,omitempty"`
+	Y   string `bson:"y,omitempty" json:"y,omitempty"`
+}
+
+// JWKS is the JSON Web Key Set document
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// SigningKey is the private key signing the JWT access tokens
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Key       crypto.Signer
+	pem       []byte
+}
+
+// PEM return the PKCS8 PEM encoding of the private key, as expected by generates.NewJWTAccessGenerate
+func (k *SigningKey) PEM() []byte {
+	return k.pem
+}
+
+// KeyConfig signing keys configuration parameters
+type KeyConfig struct {
+	// store signing keys collection name(The default is oauth2_keys)
+	KeyCName string
+	// RS256 or ES256(The default is RS256)
+	Algorithm string
+	// how long a key stays active(The default is 30 days)
+	RotationPeriod time.Duration
+	// how long a retired key stays published, longer than the access tokens lifetime(The default is 24h)
+	RetentionPeriod time.Duration
+	// when set, the private keys are stored encrypted with this AES key(16, 24 or 32 bytes)
+	EncryptionKey []byte
+}
+
+// NewDefaultKeyConfig create a default signing keys configuration
+func NewDefaultKeyConfig() *KeyConfig {
+	return &KeyConfig{
+		KeyCName:        "oauth2_keys",
+		Algorithm:       "RS256",
+		RotationPeriod:  30 * 24 * time.Hour,
+		RetentionPeriod: 24 * time.Hour,
+	}
+}
+
+type keyData struct {
+	ID          string    `bson:"_id"`
+	Algorithm   string    `bson:"Algorithm"`
+	State       KeyState  `bson:"State"`
+	Slot        KeyState  `bson:"Slot,omitempty"`
+	PrivateKey  []byte    `bson:"PrivateKey"`
+	Encrypted   bool      `bson:"Encrypted"`
+	PublicKey   JWK       `bson:"PublicKey"`
+	CreatedAt   time.Time `bson:"CreatedAt"`
+	ActivatedAt time.Time `bson:"ActivatedAt,omitempty"`
+	RotatingAt  time.Time `bson:"RotatingAt,omitempty"`
+	RetiredAt   time.Time `bson:"RetiredAt,omitempty"`
+	ExpiredAt   time.Time `bson:"ExpiredAt,omitempty"`
+}
+
+// KeyStore MongoDB storage for the JWT signing keys, with scheduled rotation
+type KeyStore struct {
+	kcfg   *KeyConfig
+	scfg   *StoreConfig
+	client *mongo.Client
+
+	mu        sync.Mutex
+	active    *SigningKey
+	expiresAt time.Time
+}
+
+// NewKeyStoreWithSession create a signing keys store instance based on mongodb,
+// an active and a next key are generated when missing
+func NewKeyStoreWithSession(client *mongo.Client, cfg *Config, kcfg *KeyConfig, scfgs ...*StoreConfig) *KeyStore {
+	if kcfg == nil {
+		kcfg = NewDefaultKeyConfig()
+	}
+
+	ks := &KeyStore{
+		kcfg:   kcfg,
+		scfg:   NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet),
+		client: client,
+	}
+	ks.scfg.override(scfgs...)
+
+	_, err := ks.c().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "State", Value: 1}, {Key: "ActivatedAt", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "Slot", Value: 1}},
+			Options: options.Index().SetName(keySlotIndex).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"Slot": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "ExpiredAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(1),
+		},
+	})
+	if err != nil {
+		ks.scfg.log().Error("Error creating index", "collection", kcfg.KeyCName, "error", err)
+		panic(err)
+	}
+
+	if err = ks.ensureKeys(context.TODO()); err != nil {
+		ks.scfg.log().Error("Error generating signing keys", "collection", kcfg.KeyCName, "error", err)
+		panic(err)
+	}
+
+	return ks
+}
+
+func (ks *KeyStore) c() *mongo.Collection {
+	return ks.client.Database(ks.scfg.db).Collection(ks.kcfg.KeyCName, ks.scfg.writeOptions(""))
+}
+
+// ensureKeys insert an active and a next key when missing,
+// the instances starting together race on the slot index and only one key per state is kept
+func (ks *KeyStore) ensureKeys(ctx context.Context) error {
+	for _, state := range []KeyState{KeyStateActive, KeyStateNext} {
+		n, err := ks.c().CountDocuments(ctx, bson.M{"State": state})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if err = ks.insertKey(ctx, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertKey generate a key and store it in the given state, unless another key already holds the slot
+func (ks *KeyStore) insertKey(ctx context.Context, state KeyState) error {
+	kd, err := ks.generateKey()
+	if err != nil {
+		return err
+	}
+	kd.State, kd.Slot = state, state
+	if state == KeyStateActive {
+		kd.ActivatedAt = kd.CreatedAt
+	}
+
+	_, err = ks.c().InsertOne(ctx, kd)
+	if isDuplicateKeyOf(err, keySlotIndex) {
+		return nil
+	}
+	return err
+}
+
+func (ks *KeyStore) generateKey() (*keyData, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	kid := base64.RawURLEncoding.EncodeToString(b)
+
+	var signer crypto.Signer
+	var jwk JWK
+	var err error
+	switch ks.kcfg.Algorithm {
+	case "", "RS256":
+		var key *rsa.PrivateKey
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
+		jwk = JWK{
+			Kty: "RSA",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case "ES256":
+		var key *ecdsa.PrivateKey
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk = JWK{
+			Kty: "EC",
+			Alg: "ES256",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", ks.kcfg.Algorithm)
+	}
+	jwk.Kid = kid
+	jwk.Use = "sig"
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	kd := &keyData{
+		ID:         kid,
+		Algorithm:  jwk.Alg,
+		PrivateKey: der,
+		PublicKey:  jwk,
+		CreatedAt:  time.Now(),
+	}
+	if len(ks.kcfg.EncryptionKey) > 0 {
+		// bound to the kid, a private key copied to another key document does not open
+		kd.PrivateKey, err = aesSeal(ks.kcfg.EncryptionKey, der, fieldAAD(kid, "PrivateKey"))
+		if err != nil {
+			return nil, err
+		}
+		kd.Encrypted = true
+	}
+	return kd, nil
+}
+
+// signingKey decode, and decrypt if needed, the private key
+func (ks *KeyStore) signingKey(kd *keyData) (*SigningKey, error) {
+	der := kd.PrivateKey
+	if kd.Encrypted {
+		if len(ks.kcfg.EncryptionKey) == 0 {
+			return nil, ErrKeyEncrypted
+		}
+		var err error
+		der, err = aesOpen(ks.kcfg.EncryptionKey, der, fieldAAD(kd.ID, "PrivateKey"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return &SigningKey{
+		ID:        kd.ID,
+		Algorithm: kd.Algorithm,
+		Key:       signer,
+		pem:       pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	}, nil
+}
+
+// ActiveKey return the most recently activated key, kept in memory for a minute
+func (ks *KeyStore) ActiveKey(ctx context.Context) (sk *SigningKey, err error) {
+	ks.mu.Lock()
+	if ks.active != nil && time.Now().Before(ks.expiresAt) {
+		sk = ks.active
+		ks.mu.Unlock()
+		return sk, nil
+	}
+	ks.mu.Unlock()
+
+	ctx, op := ks.scfg.startOperation(ctx, "KeyStore.ActiveKey", "find", ks.kcfg.KeyCName)
+	defer func() { op.endLookup(sk != nil, err) }()
+
+	ctxReq, cancel := ks.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	var kd keyData
+	err = ks.c().FindOne(ctx, bson.M{"State": KeyStateActive}, options.FindOne().SetSort(bson.D{{Key: "ActivatedAt", Value: -1}})).Decode(&kd)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoActiveKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sk, err = ks.signingKey(&kd)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	ks.active, ks.expiresAt = sk, time.Now().Add(activeKeyCacheTTL)
+	ks.mu.Unlock()
+	return sk, nil
+}
+
+// Rotate activate the next key now, retire the active one and generate a new next key
+func (ks *KeyStore) Rotate(ctx context.Context) error {
+	_, err := ks.rotate(ctx, time.Now())
+	return err
+}
+
+// RotateIfDue rotate the keys when the active one has been active for the RotationPeriod
+// only one of the instances sharing the collection performs the rotation
+func (ks *KeyStore) RotateIfDue(ctx context.Context) (bool, error) {
+	return ks.rotate(ctx, time.Now().Add(-ks.kcfg.RotationPeriod))
+}
+
+func (ks *KeyStore) rotate(ctx context.Context, activatedBefore time.Time) (rotated bool, err error) {
+	ctx, op := ks.scfg.startOperation(ctx, "KeyStore.Rotate", "update", ks.kcfg.KeyCName)
+	defer func() { op.end(err) }()
+
+	now := time.Now()
+
+	// claim the rotation of the oldest active key, it keeps signing but frees the active slot
+	var current keyData
+	err = ks.c().FindOneAndUpdate(ctx,
+		bson.M{
+			"State":       KeyStateActive,
+			"ActivatedAt": bson.M{"$lte": activatedBefore},
+			"$or": bson.A{
+				bson.M{"RotatingAt": bson.M{"$exists": false}},
+				bson.M{"RotatingAt": bson.M{"$lt": now.Add(-rotationLockTimeout)}},
+			},
+		},
+		bson.M{"$set": bson.M{"RotatingAt": now}, "$unset": bson.M{"Slot": ""}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "ActivatedAt", Value: 1}}),
+	).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// promote the next key, published long enough for the verifiers to know it
+	res, err := ks.c().UpdateOne(ctx,
+		bson.M{"State": KeyStateNext},
+		bson.M{"$set": bson.M{"State": KeyStateActive, "Slot": KeyStateActive, "ActivatedAt": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if res.MatchedCount == 0 {
+		if err = ks.insertKey(ctx, KeyStateActive); err != nil {
+			return false, err
+		}
+	}
+
+	_, err = ks.c().UpdateOne(ctx,
+		bson.M{"_id": current.ID},
+		bson.M{
+			"$set":   bson.M{"State": KeyStateRetired, "RetiredAt": now, "ExpiredAt": now.Add(ks.kcfg.RetentionPeriod)},
+			"$unset": bson.M{"RotatingAt": ""},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if err = ks.insertKey(ctx, KeyStateNext); err != nil {
+		return false, err
+	}
+
+	ks.mu.Lock()
+	ks.active = nil
+	ks.mu.Unlock()
+
+	ks.scfg.log().Info("Signing keys rotated", "collection", ks.kcfg.KeyCName, "retired", current.ID)
+	return true, nil
+}
+
+// StartRotation check every interval whether the keys are due for rotation, until ctx is done
+func (ks *KeyStore) StartRotation(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := ks.RotateIfDue(ctx); err != nil {
+				ks.scfg.log().Error("Err rotating signing keys", "collection", ks.kcfg.KeyCName, "error", err)
+			}
+		}
+	}()
+}
+
+// JWKS return the public keys of the active, next and retired keys
+func (ks *KeyStore) JWKS(ctx context.Context) (jwks *JWKS, err error) {
+	ctx, op := ks.scfg.startOperation(ctx, "KeyStore.JWKS", "find", ks.kcfg.KeyCName)
+	defer func() { op.end(err) }()
+
+	ctxReq, cancel := ks.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	cursor, err := ks.c().Find(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"ExpiredAt": bson.M{"$exists": false}},
+			bson.M{"ExpiredAt": bson.M{"$gt": time.Now()}},
+		}},
+		options.Find().SetProjection(bson.M{"PublicKey": 1}).SetSort(bson.D{{Key: "CreatedAt", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var kds []keyData
+	if err = cursor.All(ctx, &kds); err != nil {
+		return nil, err
+	}
+
+	jwks = &JWKS{Keys: []JWK{}}
+	for _, kd := range kds {
+		jwks.Keys = append(jwks.Keys, kd.PublicKey)
+	}
+	return jwks, nil
+}
+
+// JWKSHandler serve the JWKS document, for /.well-known/jwks.json
+func (ks *KeyStore) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks, err := ks.JWKS(r.Context())
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(jwks)
+	})
+}
+
+// AccessGenerate return an oauth2.AccessGenerate signing JWT access tokens with the active key
+func (ks *KeyStore) AccessGenerate() oauth2.AccessGenerate {
+	return &keyStoreAccessGenerate{ks: ks}
+}
+
+type keyStoreAccessGenerate struct {
+	ks *KeyStore
+}
+
+func (g *keyStoreAccessGenerate) Token(ctx context.Context, data *oauth2.GenerateBasic, isGenRefresh bool) (string, string, error) {
+	sk, err := g.ks.ActiveKey(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	return generates.NewJWTAccessGenerate(sk.ID, sk.PEM(), jwt.GetSigningMethod(sk.Algorithm)).Token(ctx, data, isGenRefresh)
+}
+
+// aesSeal encrypt with AES-GCM, the nonce is prepended, aad is authenticated but not stored
+func aesSeal(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// aesOpen decrypt the output of aesSeal, with the same aad
+func aesOpen(key, sealed, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
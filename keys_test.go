@@ -0,0 +1,172 @@
+package mongo
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSigningKeys(t *testing.T) {
+	Convey("Test signing keys generation", t, func() {
+		kcfg := NewDefaultKeyConfig()
+		ks := &KeyStore{kcfg: kcfg, scfg: NewDefaultStoreConfig(dbName, service, false)}
+
+		Convey("Test RS256", func() {
+			kd, err := ks.generateKey()
+			So(err, ShouldBeNil)
+			So(kd.Encrypted, ShouldBeFalse)
+			So(kd.PublicKey.Kty, ShouldEqual, "RSA")
+			So(kd.PublicKey.Kid, ShouldEqual, kd.ID)
+
+			sk, err := ks.signingKey(kd)
+			So(err, ShouldBeNil)
+			So(sk.Algorithm, ShouldEqual, "RS256")
+
+			// the access tokens verify with the published key
+			ks.active, ks.expiresAt = sk, time.Now().Add(time.Minute)
+			access, _, err := ks.AccessGenerate().Token(context.TODO(), &oauth2.GenerateBasic{
+				Client:    &models.Client{ID: "app"},
+				UserID:    "user",
+				TokenInfo: &models.Token{AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour},
+			}, false)
+			So(err, ShouldBeNil)
+
+			n, _ := base64.RawURLEncoding.DecodeString(kd.PublicKey.N)
+			e, _ := base64.RawURLEncoding.DecodeString(kd.PublicKey.E)
+			pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+			token, err := jwt.Parse(access, func(token *jwt.Token) (interface{}, error) {
+				So(token.Header["kid"], ShouldEqual, kd.ID)
+				return pub, nil
+			})
+			So(err, ShouldBeNil)
+			So(token.Valid, ShouldBeTrue)
+		})
+
+		Convey("Test ES256 encrypted", func() {
+			kcfg.Algorithm = "ES256"
+			kcfg.EncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+			kd, err := ks.generateKey()
+			So(err, ShouldBeNil)
+			So(kd.Encrypted, ShouldBeTrue)
+			So(kd.PublicKey.Crv, ShouldEqual, "P-256")
+
+			sk, err := ks.signingKey(kd)
+			So(err, ShouldBeNil)
+			So(sk.Algorithm, ShouldEqual, "ES256")
+
+			kcfg.EncryptionKey = nil
+			_, err = ks.signingKey(kd)
+			So(err, ShouldEqual, ErrKeyEncrypted)
+
+			kcfg.EncryptionKey = []byte("fedcba9876543210fedcba9876543210")
+			_, err = ks.signingKey(kd)
+			So(err, ShouldNotBeNil)
+
+			// the private key is bound to its kid
+			kcfg.EncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+			other, err := ks.generateKey()
+			So(err, ShouldBeNil)
+			other.PrivateKey = kd.PrivateKey
+			_, err = ks.signingKey(other)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Test unsupported algorithm", func() {
+			kcfg.Algorithm = "HS256"
+			_, err := ks.generateKey()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestKeyStore(t *testing.T) {
+	Convey("Test mongodb signing keys store", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		tokenStore := NewTokenStore(cfg)
+		kcfg := NewDefaultKeyConfig()
+		kcfg.KeyCName = "oauth2_keys_test"
+		kcfg.Algorithm = "ES256"
+		store := NewKeyStoreWithSession(tokenStore.client, cfg, kcfg)
+		defer store.c().Drop(context.TODO())
+
+		active, err := store.ActiveKey(context.TODO())
+		So(err, ShouldBeNil)
+
+		jwks, err := store.JWKS(context.TODO())
+		So(err, ShouldBeNil)
+		So(len(jwks.Keys), ShouldEqual, 2)
+
+		rotated, err := store.RotateIfDue(context.TODO())
+		So(err, ShouldBeNil)
+		So(rotated, ShouldBeFalse)
+
+		err = store.Rotate(context.TODO())
+		So(err, ShouldBeNil)
+
+		next, err := store.ActiveKey(context.TODO())
+		So(err, ShouldBeNil)
+		So(next.ID, ShouldNotEqual, active.ID)
+
+		var retired keyData
+		err = store.c().FindOne(context.TODO(), bson.M{"_id": active.ID}).Decode(&retired)
+		So(err, ShouldBeNil)
+		So(retired.State, ShouldEqual, KeyStateRetired)
+
+		// active, next and retired keys are published
+		rec := httptest.NewRecorder()
+		store.JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Body.String(), ShouldContainSubstring, active.ID)
+		So(rec.Body.String(), ShouldContainSubstring, next.ID)
+
+		jwks, err = store.JWKS(context.TODO())
+		So(err, ShouldBeNil)
+		So(len(jwks.Keys), ShouldEqual, 3)
+
+		Convey("Test concurrent start", func() {
+			_, err := store.c().DeleteMany(context.TODO(), bson.M{})
+			So(err, ShouldBeNil)
+
+			var wg sync.WaitGroup
+			errs := make([]error, 4)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = store.ensureKeys(context.TODO())
+				}(i)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				So(err, ShouldBeNil)
+			}
+
+			for _, state := range []KeyState{KeyStateActive, KeyStateNext} {
+				n, err := store.c().CountDocuments(context.TODO(), bson.M{"State": state})
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 1)
+			}
+		})
+	})
+}
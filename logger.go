@@ -0,0 +1,39 @@
+package mongo
+
+// Logger is the structured logger used by the stores
+// *slog.Logger satisfies it, args are alternating keys and values
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// noopLogger is the default Logger, it discards everything
+type noopLogger struct{}
+
+func (noopLogger) Debug(msg string, args ...interface{}) {}
+func (noopLogger) Info(msg string, args ...interface{})  {}
+func (noopLogger) Warn(msg string, args ...interface{})  {}
+func (noopLogger) Error(msg string, args ...interface{}) {}
+
+// SetLogger set the logger used by the stores(The default discards everything)
+func (sc *StoreConfig) SetLogger(logger Logger) *StoreConfig {
+	sc.logger = logger
+	return sc
+}
+
+func (sc *StoreConfig) log() Logger {
+	if sc == nil || sc.logger == nil {
+		return noopLogger{}
+	}
+	return sc.logger
+}
+
+// storeLogger return the logger of the optional store configs
+func storeLogger(scfgs ...*StoreConfig) Logger {
+	if len(scfgs) > 0 {
+		return scfgs[0].log()
+	}
+	return noopLogger{}
+}
@@ -0,0 +1,42 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogger(t *testing.T) {
+	Convey("Test store logger", t, func() {
+		Convey("DefaultIsNoop", func() {
+			sc := NewStoreConfig(1, 1)
+			So(sc.log(), ShouldHaveSameTypeAs, noopLogger{})
+		})
+
+		Convey("SlogTransactionFailure", func() {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+			tcfg := NewDefaultTokenConfig(NewDefaultStoreConfig(dbName, service, false).SetLogger(logger))
+			tcfg.BasicCName = "fail"
+			th := &transactionHandler{tcfg: tcfg, tw: &mockTransactionWorker{}}
+
+			info := &models.Token{Access: "access", AccessCreateAt: time.Now(), AccessExpiresIn: time.Second}
+			txnBasic := basicData{ID: "insertBasicTransactionData"}
+
+			err := th.runTransactionCreate(context.TODO(), info, txnBasic, tokenData{ID: "access"}, "an id", time.Now())
+
+			So(err, ShouldNotBeNil)
+			So(buf.String(), ShouldContainSubstring, `"level":"ERROR"`)
+			So(buf.String(), ShouldContainSubstring, `"txn_id"`)
+			So(buf.String(), ShouldNotContainSubstring, `"access"`)
+
+			record = []string{}
+		})
+	})
+}
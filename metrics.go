@@ -0,0 +1,254 @@
+package mongo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	metricsNamespace = "oauth2_mongo"
+
+	resultSuccess = "success"
+	resultHit     = "hit"
+	resultMiss    = "miss"
+	resultError   = "error"
+
+	// journalSizeTimeout bound the count of the txn collection
+	journalSizeTimeout = 2 * time.Second
+	// journalRefreshInterval is the age of the journal size above which a scrape refreshes it in the background
+	journalRefreshInterval = 15 * time.Second
+)
+
+// Metrics is a prometheus.Collector fed by the stores
+// create it with NewMetrics, set it with StoreConfig.SetMetrics and register it in your registry
+type Metrics struct {
+	operations      *prometheus.CounterVec
+	errors          *prometheus.CounterVec
+	latency         *prometheus.HistogramVec
+	duplicateKeys   *prometheus.CounterVec
+	orphanRollbacks prometheus.Counter
+	journalSize     *prometheus.Desc
+
+	mu       sync.Mutex
+	journals map[string]*journal
+}
+
+// journal is the cached size of the txn collection of a service, shared by its stores
+type journal struct {
+	count       func(ctx context.Context) (int64, error)
+	stores      int
+	size        int64
+	known       bool
+	refreshedAt time.Time
+	refreshing  bool
+}
+
+// NewMetrics create the stores metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operations_total",
+			Help:      "Number of store operations by collection, operation and result(success, hit, miss, error).",
+		}, []string{"collection", "operation", "result"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "Number of failed store operations by collection and operation.",
+		}, []string{"collection", "operation"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the store operations by collection and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"collection", "operation"}),
+		duplicateKeys: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "txn_duplicate_keys_total",
+			Help:      "Number of duplicate key errors ignored by the transaction worker.",
+		}, []string{"collection"}),
+		orphanRollbacks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "txn_orphan_rollbacks_total",
+			Help:      "Number of unfinished transactions rolled back at startup.",
+		}),
+		journalSize: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "txn_journal_size"),
+			"Number of pending entries in the transaction collection.",
+			[]string{"service"}, nil,
+		),
+		journals: make(map[string]*journal),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.operations.Describe(ch)
+	m.errors.Describe(ch)
+	m.latency.Describe(ch)
+	m.duplicateKeys.Describe(ch)
+	m.orphanRollbacks.Describe(ch)
+	ch <- m.journalSize
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.operations.Collect(ch)
+	m.errors.Collect(ch)
+	m.latency.Collect(ch)
+	m.duplicateKeys.Collect(ch)
+	m.orphanRollbacks.Collect(ch)
+
+	// the sizes are read from the cache, the stale ones are refreshed off the scrape
+	m.mu.Lock()
+	var metrics []prometheus.Metric
+	for service, j := range m.journals {
+		if j.known {
+			metrics = append(metrics, prometheus.MustNewConstMetric(m.journalSize, prometheus.GaugeValue, float64(j.size), service))
+		}
+		if !j.refreshing && time.Since(j.refreshedAt) > journalRefreshInterval {
+			j.refreshing = true
+			go m.refreshJournal(j)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, metric := range metrics {
+		ch <- metric
+	}
+}
+
+// watchJournal report the size of the txn collection of a single node TokenStore, once per service
+// the journals of a multi-tenant store are spread over the tenants databases and are not reported
+func (m *Metrics) watchJournal(tw *transactionWorker) {
+	if m == nil || tw.tc.storeConfig.multiTenant {
+		return
+	}
+	service := tw.tc.storeConfig.service
+	m.watch(service, func(ctx context.Context) (int64, error) {
+		return tw.getCollection(ctx, tw.tc.TxnCName).CountDocuments(ctx, bson.M{"Service": service})
+	})
+}
+
+func (m *Metrics) watch(service string, count func(ctx context.Context) (int64, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j, ok := m.journals[service]; ok {
+		j.stores++
+		return
+	}
+	j := &journal{count: count, stores: 1, refreshing: true}
+	m.journals[service] = j
+	go m.refreshJournal(j)
+}
+
+// unwatchJournal stop reporting the journal of the service once its last store is closed
+func (m *Metrics) unwatchJournal(service string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j, ok := m.journals[service]; ok {
+		j.stores--
+		if j.stores <= 0 {
+			delete(m.journals, service)
+		}
+	}
+}
+
+func (m *Metrics) refreshJournal(j *journal) {
+	ctx, cancel := context.WithTimeout(context.Background(), journalSizeTimeout)
+	size, err := j.count(ctx)
+	cancel()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j.size, j.known = size, err == nil
+	j.refreshedAt = time.Now()
+	j.refreshing = false
+}
+
+func (m *Metrics) observe(collection, operation, result string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.operations.WithLabelValues(collection, operation, result).Inc()
+	if result == resultError {
+		m.errors.WithLabelValues(collection, operation).Inc()
+	}
+	m.latency.WithLabelValues(collection, operation).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) duplicateKey(collection string) {
+	if m == nil {
+		return
+	}
+	m.duplicateKeys.WithLabelValues(collection).Inc()
+}
+
+func (m *Metrics) orphanRollback() {
+	if m == nil {
+		return
+	}
+	m.orphanRollbacks.Inc()
+}
+
+// SetMetrics set the collector fed by the stores
+func (sc *StoreConfig) SetMetrics(m *Metrics) *StoreConfig {
+	sc.metrics = m
+	return sc
+}
+
+// operation is an instrumented call to mongo, traced and measured
+type operation struct {
+	sc         *StoreConfig
+	span       trace.Span
+	collection string
+	name       string
+	start      time.Time
+}
+
+// startOperation start the span and the timer of an operation on a collection
+func (sc *StoreConfig) startOperation(ctx context.Context, spanName, name, collection string) (context.Context, *operation) {
+	ctx, span := sc.startSpan(ctx, spanName, name, collection)
+	return ctx, &operation{
+		sc:         sc,
+		span:       span,
+		collection: collection,
+		name:       name,
+		start:      time.Now(),
+	}
+}
+
+// end record the outcome of a write
+func (op *operation) end(err error) {
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	}
+	op.sc.metrics.observe(op.collection, op.name, result, time.Since(op.start))
+	endSpan(op.span, err)
+}
+
+// endLookup record the outcome of a read, a missing document is not an error
+func (op *operation) endLookup(found bool, err error) {
+	result := resultHit
+	switch {
+	case err == mongo.ErrNoDocuments || (err == nil && !found):
+		result = resultMiss
+		err = mongo.ErrNoDocuments
+	case err != nil:
+		result = resultError
+	}
+	op.sc.metrics.observe(op.collection, op.name, result, time.Since(op.start))
+	endSpan(op.span, err)
+}
@@ -0,0 +1,94 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Test store metrics", t, func() {
+		metrics := NewMetrics()
+		So(prometheus.NewRegistry().Register(metrics), ShouldBeNil)
+
+		sc := NewDefaultStoreConfig(dbName, service, false).SetMetrics(metrics)
+
+		Convey("Lookups", func() {
+			_, op := sc.startOperation(context.TODO(), "TokenStore.GetByAccess", "find", "oauth2_access")
+			op.endLookup(true, nil)
+			_, op = sc.startOperation(context.TODO(), "TokenStore.GetByAccess", "find", "oauth2_access")
+			op.endLookup(false, mongo.ErrNoDocuments)
+			_, op = sc.startOperation(context.TODO(), "TokenStore.GetByAccess", "find", "oauth2_access")
+			op.endLookup(false, errors.New("timeout"))
+
+			So(testutil.ToFloat64(metrics.operations.WithLabelValues("oauth2_access", "find", resultHit)), ShouldEqual, 1)
+			So(testutil.ToFloat64(metrics.operations.WithLabelValues("oauth2_access", "find", resultMiss)), ShouldEqual, 1)
+			So(testutil.ToFloat64(metrics.errors.WithLabelValues("oauth2_access", "find")), ShouldEqual, 1)
+		})
+
+		Convey("Transaction steps", func() {
+			th := &transactionHandler{tcfg: NewDefaultTokenConfig(sc), tw: &mockTransactionWorker{}}
+			info := &models.Token{
+				Access:          "access",
+				AccessCreateAt:  time.Now(),
+				AccessExpiresIn: time.Second * 5,
+			}
+
+			err := th.runTransactionCreate(context.TODO(), info, basicData{ID: "basic"}, tokenData{ID: "access"}, "basic", time.Now())
+			So(err, ShouldBeNil)
+
+			So(testutil.ToFloat64(metrics.operations.WithLabelValues("oauth2_basic", "T1", resultSuccess)), ShouldEqual, 1)
+			So(testutil.ToFloat64(metrics.operations.WithLabelValues("oauth2_access", "T2", resultSuccess)), ShouldEqual, 1)
+			So(testutil.ToFloat64(metrics.operations.WithLabelValues("oauth2_txn", "cleanup", resultSuccess)), ShouldEqual, 1)
+
+			record = []string{}
+		})
+
+		Convey("Journal size", func() {
+			var counts int32
+			count := func(ctx context.Context) (int64, error) {
+				atomic.AddInt32(&counts, 1)
+				return 3, nil
+			}
+			metrics.watch("service_a", count)
+			metrics.watch("service_a", count)
+
+			registry := prometheus.NewRegistry()
+			So(registry.Register(metrics), ShouldBeNil)
+			for i := 0; i < 100 && atomic.LoadInt32(&counts) == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+			samples := func() int {
+				n, err := testutil.GatherAndCount(registry, "oauth2_mongo_txn_journal_size")
+				So(err, ShouldBeNil)
+				return n
+			}
+
+			// one sample per service, the scrapes read the cached size
+			So(samples(), ShouldEqual, 1)
+			So(samples(), ShouldEqual, 1)
+			So(atomic.LoadInt32(&counts), ShouldEqual, 1)
+
+			metrics.unwatchJournal("service_a")
+			So(samples(), ShouldEqual, 1)
+			metrics.unwatchJournal("service_a")
+			So(samples(), ShouldEqual, 0)
+		})
+
+		Convey("Nil metrics", func() {
+			var m *Metrics
+			m.observe("c", "find", resultHit, time.Second)
+			m.duplicateKey("c")
+			m.orphanRollback()
+		})
+	})
+}
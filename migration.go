@@ -0,0 +1,330 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrMigrationLocked another instance is running the migrations
+var ErrMigrationLocked = errors.New("migrations locked by another instance")
+
+// schemaID is the _id of the document holding the schema version and the migrations lock
+const schemaID = "schema"
+
+// Migration is a change of the documents layout, applied once, in the order of the versions
+type Migration struct {
+	// Version is unique and greater than 0
+	Version     int
+	Description string
+	// Up must be idempotent, it runs again when the instance stops before its version is recorded
+	Up func(ctx context.Context, db *mongo.Database) error
+}
+
+// MigrationConfig migrations configuration parameters
+type MigrationConfig struct {
+	// store schema version collection name(The default is oauth2_migrations)
+	MigrationsCName string
+	// Migrations to apply, the ones of the module by default
+	// nil in the configuration of SetAutoMigrate runs the ones of the module on the collections of the TokenStore
+	Migrations []Migration
+	// a lock older than LockTimeout is taken over, its holder is considered dead(The default is 10 minutes)
+	// the lock is refreshed between the migrations only, a single Up must run for less than LockTimeout
+	LockTimeout time.Duration
+	// DryRun report the pending migrations without applying them
+	DryRun bool
+}
+
+// NewDefaultMigrationConfig create a default migrations configuration, with the migrations of the module
+// for the collections of tcfg, the default collection names when nil
+func NewDefaultMigrationConfig(tcfg *TokenConfig) *MigrationConfig {
+	return &MigrationConfig{
+		MigrationsCName: "oauth2_migrations",
+		Migrations:      DefaultMigrations(tcfg),
+		LockTimeout:     10 * time.Minute,
+	}
+}
+
+// DefaultMigrationConfig create a default migrations configuration for the collections of the store
+func (ts *TokenStore) DefaultMigrationConfig() *MigrationConfig {
+	return NewDefaultMigrationConfig(ts.tcfg)
+}
+
+// DefaultMigrations return the migrations of the module for the collections of tcfg,
+// the default collection names when nil
+func DefaultMigrations(tcfg *TokenConfig) []Migration {
+	if tcfg == nil {
+		tcfg = NewDefaultTokenConfig(nil)
+	}
+	basicCName := tcfg.BasicCName
+
+	return []Migration{
+		{
+			Version:     1,
+			Description: "set the UserID and ClientID of the token data stored before they were added",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				return backfillUserClient(ctx, db.Collection(basicCName))
+			},
+		},
+	}
+}
+
+// SetAutoMigrate run the migrations from the TokenStore and ClientStore constructors,
+// or on the first call of a tenant for a multi-tenant store
+// a nil mcfg runs the migrations of the module on the collections of the TokenStore, from its constructor only
+func (sc *StoreConfig) SetAutoMigrate(mcfg *MigrationConfig) *StoreConfig {
+	if mcfg == nil {
+		mcfg = NewDefaultMigrationConfig(nil)
+		mcfg.Migrations = nil
+	}
+	sc.autoMigrate = mcfg
+	return sc
+}
+
+// AppliedMigration is a migration recorded in the schema document
+type AppliedMigration struct {
+	Version     int       `bson:"Version" json:"version"`
+	Description string    `bson:"Description" json:"description"`
+	AppliedAt   time.Time `bson:"AppliedAt" json:"applied_at"`
+}
+
+type schemaData struct {
+	ID       string             `bson:"_id"`
+	Version  int                `bson:"Version"`
+	History  []AppliedMigration `bson:"History,omitempty"`
+	LockedBy string             `bson:"LockedBy,omitempty"`
+	LockedAt time.Time          `bson:"LockedAt,omitempty"`
+}
+
+// Migrator apply the migrations to the database, holding a lock so that only one instance migrates
+type Migrator struct {
+	mcfg   *MigrationConfig
+	scfg   *StoreConfig
+	client *mongo.Client
+}
+
+// NewMigratorWithSession create a migrator of the database of the configuration
+func NewMigratorWithSession(client *mongo.Client, cfg *Config, mcfg *MigrationConfig, scfgs ...*StoreConfig) *Migrator {
+	if mcfg == nil {
+		mcfg = NewDefaultMigrationConfig(nil)
+	}
+
+	m := &Migrator{
+		mcfg:   mcfg,
+		scfg:   NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet),
+		client: client,
+	}
+	m.scfg.override(scfgs...)
+	return m
+}
+
+func (m *Migrator) c(ctx context.Context) *mongo.Collection {
+	return m.client.Database(m.scfg.dbName(ctx)).Collection(m.mcfg.MigrationsCName)
+}
+
+// Version return the schema version of the database, 0 when no migration has been applied
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	schema, err := m.schema(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return schema.Version, nil
+}
+
+// History return the migrations applied to the database
+func (m *Migrator) History(ctx context.Context) ([]AppliedMigration, error) {
+	schema, err := m.schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return schema.History, nil
+}
+
+// Pending return the migrations not applied yet, in order
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return nil, err
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range migrations {
+		if mig.Version > version {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate apply the pending migrations in order and return them, or only return them in dry run
+// ErrMigrationLocked is returned while another instance migrates
+func (m *Migrator) Migrate(ctx context.Context) (applied []Migration, err error) {
+	if err = m.scfg.checkTenant(ctx); err != nil {
+		return
+	}
+	if m.mcfg.DryRun {
+		return m.Pending(ctx)
+	}
+	if _, err = m.sorted(); err != nil {
+		return
+	}
+
+	owner := primitive.NewObjectID().Hex()
+	if err = m.lock(ctx, owner); err != nil {
+		return
+	}
+	defer m.unlock(ctx, owner)
+
+	// read once locked, another instance may have migrated in between
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return
+	}
+
+	db := m.client.Database(m.scfg.dbName(ctx))
+	for _, mig := range pending {
+		m.scfg.log().Info("Applying migration", "version", mig.Version, "description", mig.Description)
+		if err = mig.Up(ctx, db); err != nil {
+			m.scfg.log().Error("Error applying migration", "version", mig.Version, "error", err)
+			return
+		}
+
+		res, err := m.c(ctx).UpdateOne(ctx, bson.M{"_id": schemaID, "LockedBy": owner}, bson.M{
+			"$set":  bson.M{"Version": mig.Version, "LockedAt": time.Now()},
+			"$push": bson.M{"History": AppliedMigration{Version: mig.Version, Description: mig.Description, AppliedAt: time.Now()}},
+		})
+		if err != nil {
+			return applied, err
+		}
+		if res.MatchedCount == 0 {
+			// the lock timed out and has been taken over
+			return applied, ErrMigrationLocked
+		}
+		applied = append(applied, mig)
+	}
+	return
+}
+
+// sorted return the migrations by version, checking they are valid
+func (m *Migrator) sorted() ([]Migration, error) {
+	migrations := append([]Migration{}, m.mcfg.Migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, mig := range migrations {
+		if mig.Version <= 0 || mig.Up == nil {
+			return nil, fmt.Errorf("invalid migration %d", mig.Version)
+		}
+		if i > 0 && migrations[i-1].Version == mig.Version {
+			return nil, fmt.Errorf("duplicate migration %d", mig.Version)
+		}
+	}
+	return migrations, nil
+}
+
+func (m *Migrator) schema(ctx context.Context) (*schemaData, error) {
+	var schema schemaData
+	err := m.c(ctx).FindOne(ctx, bson.M{"_id": schemaID}).Decode(&schema)
+	if err == mongo.ErrNoDocuments {
+		return &schemaData{ID: schemaID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// lock take the migrations lock, unless another instance holds it for less than LockTimeout
+func (m *Migrator) lock(ctx context.Context, owner string) error {
+	now := time.Now()
+	_, err := m.c(ctx).UpdateOne(ctx,
+		bson.M{
+			"_id": schemaID,
+			"$or": bson.A{
+				bson.M{"LockedBy": bson.M{"$exists": false}},
+				bson.M{"LockedAt": bson.M{"$lt": now.Add(-m.mcfg.LockTimeout)}},
+			},
+		},
+		bson.M{"$set": bson.M{"LockedBy": owner, "LockedAt": now}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// the schema document exists and is locked
+		return ErrMigrationLocked
+	}
+	return err
+}
+
+func (m *Migrator) unlock(ctx context.Context, owner string) {
+	_, err := m.c(ctx).UpdateOne(ctx, bson.M{"_id": schemaID, "LockedBy": owner}, bson.M{"$unset": bson.M{"LockedBy": "", "LockedAt": ""}})
+	if err != nil {
+		m.scfg.log().Error("Error releasing the migrations lock", "collection", m.mcfg.MigrationsCName, "error", err)
+	}
+}
+
+// autoMigrate run the migrations of the store configuration, waiting for another instance migrating
+// the migrations of the module are resolved for the collections of tcfg, nil from a ClientStore leaves them to the TokenStore
+func autoMigrate(ctx context.Context, client *mongo.Client, sc *StoreConfig, tcfg *TokenConfig) error {
+	mcfg := sc.autoMigrate
+	if mcfg.Migrations == nil {
+		if tcfg == nil {
+			return nil
+		}
+		resolved := *mcfg
+		resolved.Migrations = DefaultMigrations(tcfg)
+		mcfg = &resolved
+	}
+
+	m := &Migrator{mcfg: mcfg, scfg: sc, client: client}
+	deadline := time.Now().Add(m.mcfg.LockTimeout)
+	for {
+		_, err := m.Migrate(ctx)
+		if err != ErrMigrationLocked || time.Now().After(deadline) {
+			return err
+		}
+		sc.log().Info("Waiting for the migrations of another instance")
+		time.Sleep(time.Second)
+	}
+}
+
+// backfillUserClient set the UserID and ClientID of the token data, from their payload,
+// for RevokeByUserClient, the sealed payloads were stored with them
+// the documents set already are not matched, so it can run again
+func backfillUserClient(ctx context.Context, coll *mongo.Collection) error {
+	cursor, err := coll.Find(ctx, bson.M{"UserID": bson.M{"$exists": false}, "Data": bson.M{"$type": "binData"}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var bd basicData
+		if err := cursor.Decode(&bd); err != nil {
+			return err
+		}
+		var tm models.Token
+		if err := json.Unmarshal(bd.Data, &tm); err != nil {
+			continue
+		}
+		if tm.UserID == "" && tm.ClientID == "" {
+			continue
+		}
+		_, err := coll.UpdateOne(ctx, bson.M{"_id": bd.ID}, bson.M{"$set": bson.M{"UserID": tm.UserID, "ClientID": tm.ClientID}})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
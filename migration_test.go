@@ -0,0 +1,145 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrationsOrder(t *testing.T) {
+	Convey("Test migrations validation", t, func() {
+		up := func(ctx context.Context, db *mongo.Database) error { return nil }
+		m := &Migrator{mcfg: NewDefaultMigrationConfig(nil)}
+
+		_, err := m.sorted()
+		So(err, ShouldBeNil)
+
+		m.mcfg.Migrations = []Migration{{Version: 3, Up: up}, {Version: 1, Up: up}, {Version: 2, Up: up}}
+		migrations, err := m.sorted()
+		So(err, ShouldBeNil)
+		So(migrations[0].Version, ShouldEqual, 1)
+		So(migrations[2].Version, ShouldEqual, 3)
+
+		m.mcfg.Migrations = []Migration{{Version: 1, Up: up}, {Version: 1, Up: up}}
+		_, err = m.sorted()
+		So(err, ShouldNotBeNil)
+
+		m.mcfg.Migrations = []Migration{{Version: 0, Up: up}}
+		_, err = m.sorted()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAutoMigrateDefault(t *testing.T) {
+	Convey("Test the migrations of the module run from the constructors", t, func() {
+		sc := NewStoreConfig(0, 0).SetAutoMigrate(nil)
+		So(sc.autoMigrate, ShouldNotBeNil)
+		So(sc.autoMigrate.Migrations, ShouldBeNil)
+
+		// resolved for the collections of the TokenStore, the ClientStore has none
+		So(autoMigrate(context.TODO(), nil, sc, nil), ShouldBeNil)
+
+		tcfg := NewDefaultTokenConfig(nil)
+		tcfg.BasicCName = "oauth2_basic_custom"
+		ts := &TokenStore{tcfg: tcfg}
+		So(ts.DefaultMigrationConfig().Migrations, ShouldHaveLength, 1)
+	})
+}
+
+func TestMigrator(t *testing.T) {
+	Convey("Test mongodb migrations", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		tokenStore := NewTokenStore(cfg)
+		var runs []int
+		mcfg := NewDefaultMigrationConfig(nil)
+		mcfg.MigrationsCName = "oauth2_migrations_test"
+		mcfg.Migrations = []Migration{
+			{Version: 2, Description: "second", Up: func(ctx context.Context, db *mongo.Database) error {
+				runs = append(runs, 2)
+				return nil
+			}},
+			{Version: 1, Description: "first", Up: func(ctx context.Context, db *mongo.Database) error {
+				runs = append(runs, 1)
+				return nil
+			}},
+		}
+		m := NewMigratorWithSession(tokenStore.client, cfg, mcfg)
+		defer m.c(context.TODO()).Drop(context.TODO())
+
+		mcfg.DryRun = true
+		pending, err := m.Migrate(context.TODO())
+		So(err, ShouldBeNil)
+		So(len(pending), ShouldEqual, 2)
+		So(runs, ShouldBeEmpty)
+
+		mcfg.DryRun = false
+		applied, err := m.Migrate(context.TODO())
+		So(err, ShouldBeNil)
+		So(len(applied), ShouldEqual, 2)
+		So(runs, ShouldResemble, []int{1, 2})
+
+		version, err := m.Version(context.TODO())
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 2)
+
+		history, err := m.History(context.TODO())
+		So(err, ShouldBeNil)
+		So(history[0].Description, ShouldEqual, "first")
+
+		applied, err = m.Migrate(context.TODO())
+		So(err, ShouldBeNil)
+		So(applied, ShouldBeEmpty)
+
+		// another instance holds the lock
+		_, err = m.c(context.TODO()).UpdateOne(context.TODO(), bson.M{"_id": schemaID}, bson.M{"$set": bson.M{"LockedBy": "other", "LockedAt": time.Now()}})
+		So(err, ShouldBeNil)
+		_, err = m.Migrate(context.TODO())
+		So(err, ShouldEqual, ErrMigrationLocked)
+	})
+}
+
+func TestDefaultMigrations(t *testing.T) {
+	Convey("Test the migrations of the module on the collections of the token configuration", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		tokenStore := NewTokenStore(cfg)
+		tcfg := NewDefaultTokenConfig(nil)
+		tcfg.BasicCName = "oauth2_basic_migration_test"
+		db := tokenStore.client.Database(dbName)
+		coll := db.Collection(tcfg.BasicCName)
+		defer coll.Drop(context.TODO())
+
+		_, err := coll.InsertOne(context.TODO(), bson.M{
+			"_id":       "migration_1",
+			"Data":      []byte(`{"ClientID":"1","UserID":"1_9"}`),
+			"ExpiredAt": time.Now().Add(time.Minute),
+		})
+		So(err, ShouldBeNil)
+
+		// run twice, as after an interrupted migration
+		for i := 0; i < 2; i++ {
+			So(DefaultMigrations(tcfg)[0].Up(context.TODO(), db), ShouldBeNil)
+		}
+
+		var bd basicData
+		So(coll.FindOne(context.TODO(), bson.M{"_id": "migration_1"}).Decode(&bd), ShouldBeNil)
+		So(bd.UserID, ShouldEqual, "1_9")
+		So(bd.ClientID, ShouldEqual, "1")
+	})
+}
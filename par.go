@@ -0,0 +1,245 @@
+package mongo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// requestURIPrefix is the URN namespace of the request_uri values(RFC 9126 section 2.2)
+const requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+var (
+	// ErrInvalidRequestURI the request_uri is unknown, expired, already used or pushed by another client
+	ErrInvalidRequestURI = errors.New("invalid_request_uri")
+	// ErrInvalidPushedRequest the pushed parameters are not acceptable
+	ErrInvalidPushedRequest = errors.New("invalid_request")
+)
+
+// PushedRequest is a pushed authorization request
+type PushedRequest struct {
+	RequestURI string              `bson:"_id"`
+	ClientID   string              `bson:"ClientID"`
+	Params     map[string][]string `bson:"Params"`
+	CreatedAt  time.Time           `bson:"CreatedAt"`
+	ExpiredAt  time.Time           `bson:"ExpiredAt"`
+}
+
+// PARConfig pushed authorization requests configuration parameters
+type PARConfig struct {
+	// store pushed requests collection name(The default is oauth2_par)
+	PARCName string
+	// lifetime of a request_uri(The default is 60s)
+	Lifetime time.Duration
+}
+
+// NewDefaultPARConfig create a default pushed authorization requests configuration
+func NewDefaultPARConfig() *PARConfig {
+	return &PARConfig{
+		PARCName: "oauth2_par",
+		Lifetime: time.Minute,
+	}
+}
+
+// PARStore MongoDB storage for the pushed authorization requests(RFC 9126)
+type PARStore struct {
+	pcfg   *PARConfig
+	scfg   *StoreConfig
+	client *mongo.Client
+}
+
+// NewPARStoreWithSession create a pushed authorization requests store instance based on mongodb
+func NewPARStoreWithSession(client *mongo.Client, cfg *Config, pcfg *PARConfig, scfgs ...*StoreConfig) *PARStore {
+	if pcfg == nil {
+		pcfg = NewDefaultPARConfig()
+	}
+
+	ps := &PARStore{
+		pcfg:   pcfg,
+		scfg:   NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet),
+		client: client,
+	}
+	ps.scfg.override(scfgs...)
+
+	_, err := ps.c().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "ExpiredAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(1),
+	})
+	if err != nil {
+		ps.scfg.log().Error("Error creating index", "collection", pcfg.PARCName, "error", err)
+		panic(err)
+	}
+
+	return ps
+}
+
+func (ps *PARStore) c() *mongo.Collection {
+	return ps.client.Database(ps.scfg.db).Collection(ps.pcfg.PARCName, ps.scfg.writeOptions(""))
+}
+
+// Push store the authorization request parameters of the authenticated client under a new request_uri
+func (ps *PARStore) Push(ctx context.Context, clientID string, params neturl.Values) (pr *PushedRequest, err error) {
+	ctx, op := ps.scfg.startOperation(ctx, "PARStore.Push", "insert", ps.pcfg.PARCName)
+	defer func() { op.end(err) }()
+
+	// a pushed request can't reference another one, and belongs to the pushing client
+	if params.Get("request_uri") != "" {
+		return nil, ErrInvalidPushedRequest
+	}
+	if id := params.Get("client_id"); id != "" && id != clientID {
+		return nil, ErrInvalidPushedRequest
+	}
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	pr = &PushedRequest{
+		RequestURI: requestURIPrefix + base64.RawURLEncoding.EncodeToString(b),
+		ClientID:   clientID,
+		Params:     params,
+		CreatedAt:  now,
+		ExpiredAt:  now.Add(ps.pcfg.Lifetime),
+	}
+
+	ctxReq, cancel := ps.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	if _, err = ps.c().InsertOne(ctx, pr); err != nil {
+		ps.scfg.log().Error("Error PARStore.Push", "operation", "insert", "collection", ps.pcfg.PARCName, "error", err)
+		return nil, err
+	}
+	return pr, nil
+}
+
+// Consume remove and return the parameters pushed under request_uri by the client, client_id included
+// ErrInvalidRequestURI is returned for an unknown, expired or already used request_uri, or another client
+func (ps *PARStore) Consume(ctx context.Context, requestURI, clientID string) (params neturl.Values, err error) {
+	ctx, op := ps.scfg.startOperation(ctx, "PARStore.Consume", "delete", ps.pcfg.PARCName)
+	defer func() {
+		if err == ErrInvalidRequestURI {
+			op.endLookup(false, nil)
+			return
+		}
+		op.endLookup(params != nil, err)
+	}()
+
+	ctxReq, cancel := ps.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	var pr PushedRequest
+	err = ps.c().FindOneAndDelete(ctx, bson.M{
+		"_id":       requestURI,
+		"ClientID":  clientID,
+		"ExpiredAt": bson.M{"$gt": time.Now()},
+	}).Decode(&pr)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidRequestURI
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	params = neturl.Values(pr.Params)
+	if params == nil {
+		params = neturl.Values{}
+	}
+	// the client may authenticate the push without repeating client_id in the body
+	params.Set("client_id", pr.ClientID)
+	return params, nil
+}
+
+// AuthorizeRequest replace the parameters of an authorize request holding a request_uri with the pushed ones,
+// to be called before server.HandleAuthorizeRequest
+// requests without request_uri are left untouched
+func (ps *PARStore) AuthorizeRequest(r *http.Request) error {
+	query := r.URL.Query()
+	requestURI := query.Get("request_uri")
+	if requestURI == "" {
+		return nil
+	}
+
+	params, err := ps.Consume(r.Context(), requestURI, query.Get("client_id"))
+	if err != nil {
+		return err
+	}
+
+	r.URL.RawQuery = params.Encode()
+	r.Form = params
+	return nil
+}
+
+// PARResponse is the RFC 9126 pushed authorization response
+type PARResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+// PARHandler implements the RFC 9126 pushed authorization request endpoint
+type PARHandler struct {
+	parStore    *PARStore
+	clientStore oauth2.ClientStore
+}
+
+// NewPARHandler create the pushed authorization request endpoint handler
+func NewPARHandler(ps *PARStore, cs oauth2.ClientStore) *PARHandler {
+	return &PARHandler{
+		parStore:    ps,
+		clientStore: cs,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (ph *PARHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, endpointError{Error: "invalid_request"})
+		return
+	}
+
+	cli, err := authenticateClient(r, ph.clientStore)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	params := neturl.Values{}
+	for k, v := range r.PostForm {
+		if k == "client_secret" {
+			continue
+		}
+		params[k] = v
+	}
+
+	pr, err := ph.parStore.Push(r.Context(), cli.GetID(), params)
+	if err == ErrInvalidPushedRequest {
+		writeJSON(w, http.StatusBadRequest, endpointError{Error: "invalid_request"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, endpointError{Error: "temporarily_unavailable"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, PARResponse{
+		RequestURI: pr.RequestURI,
+		ExpiresIn:  int64(pr.ExpiredAt.Sub(pr.CreatedAt) / time.Second),
+	})
+}
@@ -0,0 +1,102 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPARHandler(t *testing.T) {
+	Convey("Test pushed authorization request handler", t, func() {
+		clients := mockClientStore{
+			"app": &models.Client{ID: "app", Secret: "app_secret"},
+		}
+		ps := &PARStore{pcfg: NewDefaultPARConfig(), scfg: NewDefaultStoreConfig(dbName, service, false)}
+		handler := NewPARHandler(ps, clients)
+
+		Convey("InvalidClient", func() {
+			rec := postForm(handler, neturl.Values{"response_type": {"code"}}, "app", "wrong")
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("RequestURIParameter", func() {
+			rec := postForm(handler, neturl.Values{"request_uri": {requestURIPrefix + "x"}}, "app", "app_secret")
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("OtherClientID", func() {
+			rec := postForm(handler, neturl.Values{"client_id": {"other"}}, "app", "app_secret")
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("AuthorizeRequestWithoutRequestURI", func() {
+			req := httptest.NewRequest(http.MethodGet, "/authorize?response_type=code&client_id=app", nil)
+			So(ps.AuthorizeRequest(req), ShouldBeNil)
+			So(req.URL.Query().Get("response_type"), ShouldEqual, "code")
+		})
+	})
+}
+
+func TestPARStore(t *testing.T) {
+	Convey("Test mongodb pushed authorization requests store", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		tokenStore := NewTokenStore(cfg)
+		store := NewPARStoreWithSession(tokenStore.client, cfg, nil)
+
+		clients := mockClientStore{
+			"app": &models.Client{ID: "app", Secret: "app_secret"},
+		}
+		handler := NewPARHandler(store, clients)
+
+		rec := postForm(handler, neturl.Values{
+			"response_type": {"code"},
+			"redirect_uri":  {"http://localhost/cb"},
+			"scope":         {"all"},
+		}, "app", "app_secret")
+		So(rec.Code, ShouldEqual, http.StatusCreated)
+
+		var resp PARResponse
+		So(json.NewDecoder(rec.Body).Decode(&resp), ShouldBeNil)
+		So(resp.RequestURI, ShouldStartWith, requestURIPrefix)
+		So(resp.ExpiresIn, ShouldEqual, 60)
+
+		Convey("Test other client", func() {
+			_, err := store.Consume(context.TODO(), resp.RequestURI, "other")
+			So(err, ShouldEqual, ErrInvalidRequestURI)
+		})
+
+		Convey("Test push without client_id", func() {
+			pr, err := store.Push(context.TODO(), "app", neturl.Values{"response_type": {"code"}})
+			So(err, ShouldBeNil)
+
+			params, err := store.Consume(context.TODO(), pr.RequestURI, "app")
+			So(err, ShouldBeNil)
+			So(params.Get("client_id"), ShouldEqual, "app")
+			So(params.Get("response_type"), ShouldEqual, "code")
+		})
+
+		Convey("Test single use", func() {
+			req := httptest.NewRequest(http.MethodGet, "/authorize?client_id=app&request_uri="+neturl.QueryEscape(resp.RequestURI), nil)
+			err := store.AuthorizeRequest(req)
+			So(err, ShouldBeNil)
+			So(req.FormValue("redirect_uri"), ShouldEqual, "http://localhost/cb")
+			So(req.FormValue("client_secret"), ShouldEqual, "")
+			So(req.FormValue("client_id"), ShouldEqual, "app")
+
+			_, err = store.Consume(context.TODO(), resp.RequestURI, "app")
+			So(err, ShouldEqual, ErrInvalidRequestURI)
+		})
+	})
+}
@@ -0,0 +1,95 @@
+package mongo
+
+import (
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// ReadOperation is a lookup whose read preference and read concern can be configured
+type ReadOperation string
+
+const (
+	ReadGetByCode     ReadOperation = "GetByCode"
+	ReadGetByAccess   ReadOperation = "GetByAccess"
+	ReadGetByRefresh  ReadOperation = "GetByRefresh"
+	ReadClientGetByID ReadOperation = "ClientGetByID"
+)
+
+// SetReadPreference set the read preference of the lookups, all of them when no operation is given,
+// e.g. readpref.Secondary(readpref.WithMaxStaleness(90*time.Second)) for the access tokens of a resource server
+func (sc *StoreConfig) SetReadPreference(rp *readpref.ReadPref, ops ...ReadOperation) *StoreConfig {
+	if sc.readPrefs == nil {
+		sc.readPrefs = map[ReadOperation]*readpref.ReadPref{}
+	}
+	if len(ops) == 0 {
+		ops = []ReadOperation{""}
+	}
+	for _, op := range ops {
+		sc.readPrefs[op] = rp
+	}
+	return sc
+}
+
+// SetReadConcern set the read concern of the lookups, all of them when no operation is given
+func (sc *StoreConfig) SetReadConcern(rc *readconcern.ReadConcern, ops ...ReadOperation) *StoreConfig {
+	if sc.readConcerns == nil {
+		sc.readConcerns = map[ReadOperation]*readconcern.ReadConcern{}
+	}
+	if len(ops) == 0 {
+		ops = []ReadOperation{""}
+	}
+	for _, op := range ops {
+		sc.readConcerns[op] = rc
+	}
+	return sc
+}
+
+// SetPrimaryFallback retry on the primary the lookups missing on a secondary,
+// so that a token created a moment ago and not yet replicated is still found
+func (sc *StoreConfig) SetPrimaryFallback(enabled bool) *StoreConfig {
+	sc.primaryFallback = enabled
+	return sc
+}
+
+// readPref return the read preference of the operation, nil for the client default
+func (sc *StoreConfig) readPref(op ReadOperation) *readpref.ReadPref {
+	if rp, ok := sc.readPrefs[op]; ok {
+		return rp
+	}
+	return sc.readPrefs[""]
+}
+
+// readOptions return the collection options of the lookup, nil when nothing is configured,
+// primary forces the primary read preference
+func (sc *StoreConfig) readOptions(op ReadOperation, primary bool) *options.CollectionOptions {
+	rp := sc.readPref(op)
+	if primary {
+		rp = readpref.Primary()
+	}
+	rc, ok := sc.readConcerns[op]
+	if !ok {
+		rc = sc.readConcerns[""]
+	}
+	if rp == nil && rc == nil {
+		return nil
+	}
+
+	opts := options.Collection()
+	if rp != nil {
+		opts.SetReadPreference(rp)
+	}
+	if rc != nil {
+		opts.SetReadConcern(rc)
+	}
+	return opts
+}
+
+// fallsBack report whether a miss of the operation must be retried on the primary
+func (sc *StoreConfig) fallsBack(op ReadOperation) bool {
+	if !sc.primaryFallback {
+		return false
+	}
+	rp := sc.readPref(op)
+	return rp != nil && rp.Mode() != readpref.PrimaryMode
+}
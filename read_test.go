@@ -0,0 +1,41 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadOptions(t *testing.T) {
+	Convey("Test lookups read preference and read concern", t, func() {
+		sc := NewStoreConfig(0, 0)
+
+		So(sc.readOptions(ReadGetByAccess, false), ShouldBeNil)
+		So(sc.fallsBack(ReadGetByAccess), ShouldBeFalse)
+
+		secondary := readpref.Secondary(readpref.WithMaxStaleness(90 * time.Second))
+		sc.SetReadPreference(readpref.PrimaryPreferred()).
+			SetReadPreference(secondary, ReadGetByAccess).
+			SetReadConcern(readconcern.Majority(), ReadGetByRefresh).
+			SetPrimaryFallback(true)
+
+		opts := sc.readOptions(ReadGetByAccess, false)
+		So(opts.ReadPreference.Mode(), ShouldEqual, readpref.SecondaryMode)
+		So(opts.ReadConcern, ShouldBeNil)
+		So(sc.fallsBack(ReadGetByAccess), ShouldBeTrue)
+
+		opts = sc.readOptions(ReadGetByAccess, true)
+		So(opts.ReadPreference.Mode(), ShouldEqual, readpref.PrimaryMode)
+
+		opts = sc.readOptions(ReadGetByRefresh, false)
+		So(opts.ReadPreference.Mode(), ShouldEqual, readpref.PrimaryPreferredMode)
+		So(opts.ReadConcern, ShouldResemble, readconcern.Majority())
+
+		sc.SetReadPreference(readpref.Primary(), ReadClientGetByID)
+		So(sc.fallsBack(ReadClientGetByID), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,127 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrJTIReplayed the assertion id has already been used by the issuer
+	ErrJTIReplayed = errors.New("jti already used")
+	// ErrJTIExpired the assertion has expired, it can't be accepted nor recorded
+	ErrJTIExpired = errors.New("jti expired")
+)
+
+// ReplayConfig assertion ids collection configuration parameters
+type ReplayConfig struct {
+	// store assertion ids collection name(The default is oauth2_jti)
+	ReplayCName string
+	// an id is kept this long after the expiration of its assertion,
+	// to cover the clock skew accepted by the verifier(The default is 1m)
+	ClockSkew time.Duration
+}
+
+// NewDefaultReplayConfig create a default assertion ids configuration
+func NewDefaultReplayConfig() *ReplayConfig {
+	return &ReplayConfig{
+		ReplayCName: "oauth2_jti",
+		ClockSkew:   time.Minute,
+	}
+}
+
+type replayData struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Issuer    string             `bson:"Issuer"`
+	JTI       string             `bson:"JTI"`
+	ExpiredAt time.Time          `bson:"ExpiredAt"`
+}
+
+// ReplayStore record the ids(jti) of the JWT assertions used for client authentication
+// and JWT bearer grants, so that an assertion is accepted once
+type ReplayStore struct {
+	rcfg   *ReplayConfig
+	scfg   *StoreConfig
+	client *mongo.Client
+}
+
+// NewReplayStoreWithSession create an assertion ids store instance based on mongodb
+func NewReplayStoreWithSession(client *mongo.Client, cfg *Config, rcfg *ReplayConfig, scfgs ...*StoreConfig) *ReplayStore {
+	if rcfg == nil {
+		rcfg = NewDefaultReplayConfig()
+	}
+
+	rs := &ReplayStore{
+		rcfg:   rcfg,
+		scfg:   NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet),
+		client: client,
+	}
+	rs.scfg.override(scfgs...)
+
+	_, err := rs.c().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "Issuer", Value: 1}, {Key: "JTI", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "ExpiredAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(1),
+		},
+	})
+	if err != nil {
+		rs.scfg.log().Error("Error creating index", "collection", rcfg.ReplayCName, "error", err)
+		panic(err)
+	}
+
+	return rs
+}
+
+func (rs *ReplayStore) c() *mongo.Collection {
+	return rs.client.Database(rs.scfg.db).Collection(rs.rcfg.ReplayCName, rs.scfg.writeOptions(""))
+}
+
+// CheckAndStore record the assertion id of the issuer until exp, plus the clock skew
+// ErrJTIReplayed is returned when the id is already recorded, ErrJTIExpired when exp is past
+func (rs *ReplayStore) CheckAndStore(ctx context.Context, issuer, jti string, exp time.Time) (err error) {
+	ctx, op := rs.scfg.startOperation(ctx, "ReplayStore.CheckAndStore", "insert", rs.rcfg.ReplayCName)
+	defer func() {
+		// a replay is the expected answer, not a store error
+		if err == ErrJTIReplayed || err == ErrJTIExpired {
+			op.end(nil)
+			return
+		}
+		op.end(err)
+	}()
+
+	expiredAt := exp.Add(rs.rcfg.ClockSkew)
+	if !expiredAt.After(time.Now()) {
+		return ErrJTIExpired
+	}
+
+	ctxReq, cancel := rs.scfg.setRequestContext()
+	defer cancel()
+	if ctxReq != nil {
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	// the unique index makes the check and the insert atomic
+	_, err = rs.c().InsertOne(ctx, replayData{
+		ID:        primitive.NewObjectID(),
+		Issuer:    issuer,
+		JTI:       jti,
+		ExpiredAt: expiredAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		rs.scfg.log().Warn("JWT assertion replayed", "collection", rs.rcfg.ReplayCName, "issuer", issuer)
+		return ErrJTIReplayed
+	}
+	if err != nil {
+		rs.scfg.log().Error("Error ReplayStore.CheckAndStore", "operation", "insert", "collection", rs.rcfg.ReplayCName, "error", err)
+	}
+	return
+}
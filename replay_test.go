@@ -0,0 +1,39 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReplayStore(t *testing.T) {
+	Convey("Test mongodb assertion ids store", t, func() {
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		tokenStore := NewTokenStore(cfg)
+		store := NewReplayStoreWithSession(tokenStore.client, cfg, nil)
+
+		jti := time.Now().Format(time.RFC3339Nano)
+		exp := time.Now().Add(time.Minute)
+
+		err := store.CheckAndStore(context.TODO(), "client_a", jti, exp)
+		So(err, ShouldBeNil)
+
+		err = store.CheckAndStore(context.TODO(), "client_a", jti, exp)
+		So(err, ShouldEqual, ErrJTIReplayed)
+
+		// the ids are scoped by issuer
+		err = store.CheckAndStore(context.TODO(), "client_b", jti, exp)
+		So(err, ShouldBeNil)
+
+		err = store.CheckAndStore(context.TODO(), "client_a", jti+"_old", time.Now().Add(-time.Hour))
+		So(err, ShouldEqual, ErrJTIExpired)
+	})
+}
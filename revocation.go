@@ -0,0 +1,381 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// revocationRetention is how long the tombstones of the polling mode are kept
+	revocationRetention = 24 * time.Hour
+	// watchRetryDelay is the delay before reopening a failed change stream
+	watchRetryDelay = time.Second
+	// revocationSkew is how far the clocks of the instances writing the tombstones may drift apart,
+	// each poll reads again the tombstones of that window before the last one delivered
+	revocationSkew = time.Minute
+)
+
+// ErrRevocationWatchUnavailable is returned by WatchRevocations on a single node without polling enabled
+var ErrRevocationWatchUnavailable = errors.New("change streams need a replicaSet, enable the polling mode with StoreConfig.SetRevocationPolling")
+
+// RevocationEvent is sent when a token is removed from the store
+type RevocationEvent struct {
+	// TokenType is access, refresh or basic(authorization code or token data)
+	TokenType string
+	// Token is the removed access token, refresh token, or basic id
+	Token string
+	// RemovedAt is the time of the removal
+	RemovedAt time.Time
+}
+
+// revocationData is the tombstone written in RevocationsCName in polling mode
+type revocationData struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	TokenType string             `bson:"TokenType"`
+	Token     string             `bson:"Token"`
+	RevokedAt time.Time          `bson:"RevokedAt"`
+}
+
+// checkpointData is the position of a watcher, saved in CheckpointsCName
+type checkpointData struct {
+	ID          string             `bson:"_id"`
+	ResumeToken bson.Raw           `bson:"ResumeToken,omitempty"`
+	LastID      primitive.ObjectID `bson:"LastID,omitempty"`
+	UpdatedAt   time.Time          `bson:"UpdatedAt"`
+}
+
+// SetRevocationPolling enable the polling mode of WatchRevocations for a single node, where change streams are not available
+// removed tokens are recorded in RevocationsCName and polled at the given interval
+func (sc *StoreConfig) SetRevocationPolling(interval time.Duration) *StoreConfig {
+	sc.revocationPolling = interval
+	return sc
+}
+
+// SetRevocationConsumer name the instance watching the revocations, its position is saved under that name
+// and WatchRevocations resumes from it after a restart, each instance has to use its own stable name
+func (sc *StoreConfig) SetRevocationConsumer(name string) *StoreConfig {
+	sc.watchConsumer = name
+	return sc
+}
+
+func (ts *TokenStore) pollingEnabled() bool {
+	return !ts.tcfg.storeConfig.isReplicaSet && ts.tcfg.storeConfig.revocationPolling > 0
+}
+
+// WatchRevocations deliver an event each time an access, refresh or basic document is removed,
+// by a RemoveBy* call or by the TTL index
+// on a replicaSet it relies on change streams, on a single node it polls the tombstones written by
+// RemoveByAccess and RemoveByRefresh, expirations and removed basic documents are not reported in that mode
+// the position of the consumer set by SetRevocationConsumer is saved in CheckpointsCName and a watcher of the
+// same consumer resumes from it, without consumer the events are delivered from now on
+// the channel is closed when ctx is done
+func (ts *TokenStore) WatchRevocations(ctx context.Context) (<-chan RevocationEvent, error) {
+	if err := ts.tenant(ctx); err != nil {
+		return nil, err
+	}
+
+	consumer := ts.tcfg.storeConfig.watchConsumer
+	ch := make(chan RevocationEvent)
+
+	if ts.tcfg.storeConfig.isReplicaSet {
+		cs, err := ts.openRevocationStream(ctx, consumer, nil)
+		if err != nil {
+			return nil, err
+		}
+		go ts.watchChangeStream(ctx, consumer, cs, ch)
+		return ch, nil
+	}
+
+	if !ts.pollingEnabled() {
+		return nil, ErrRevocationWatchUnavailable
+	}
+
+	window, err := ts.startPollWindow(ctx, consumer)
+	if err != nil {
+		return nil, err
+	}
+	go ts.pollRevocations(ctx, consumer, window, ch)
+	return ch, nil
+}
+
+func (ts *TokenStore) checkpointID(consumer string) string {
+	return ts.tcfg.storeConfig.service + "/revocations/" + consumer
+}
+
+func (ts *TokenStore) loadCheckpoint(ctx context.Context, consumer string) (*checkpointData, error) {
+	if consumer == "" {
+		return nil, nil
+	}
+
+	var cp checkpointData
+	err := ts.c(ctx, ts.tcfg.CheckpointsCName).FindOne(ctx, bson.M{"_id": ts.checkpointID(consumer)}).Decode(&cp)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (ts *TokenStore) saveCheckpoint(ctx context.Context, consumer string, update bson.M) {
+	if consumer == "" {
+		return
+	}
+
+	update["UpdatedAt"] = time.Now()
+	_, err := ts.c(ctx, ts.tcfg.CheckpointsCName, ts.tcfg.storeConfig.writeOptions(WriteTokenRevoke)).UpdateOne(ctx,
+		bson.M{"_id": ts.checkpointID(consumer)},
+		bson.M{"$set": update},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		ts.tcfg.storeConfig.log().Error("Err saving revocations checkpoint", "collection", ts.tcfg.CheckpointsCName, "error", err)
+	}
+}
+
+func (ts *TokenStore) tokenType(collection string) string {
+	switch collection {
+	case ts.tcfg.AccessCName:
+		return "access"
+	case ts.tcfg.RefreshCName:
+		return "refresh"
+	default:
+		return "basic"
+	}
+}
+
+// openRevocationStream watch the deletes on the token collections, from resumeToken, the position reached
+// before an interruption, else from the saved checkpoint if any
+func (ts *TokenStore) openRevocationStream(ctx context.Context, consumer string, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "operationType", Value: "delete"},
+			{Key: "ns.coll", Value: bson.D{{Key: "$in", Value: bson.A{ts.tcfg.BasicCName, ts.tcfg.AccessCName, ts.tcfg.RefreshCName}}}},
+		}}},
+	}
+
+	opts := options.ChangeStream()
+	if len(resumeToken) == 0 {
+		cp, err := ts.loadCheckpoint(ctx, consumer)
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil {
+			resumeToken = cp.ResumeToken
+		}
+	}
+	if len(resumeToken) > 0 {
+		opts.SetResumeAfter(resumeToken)
+	}
+
+	return ts.client.Database(ts.tcfg.storeConfig.dbName(ctx)).Watch(ctx, pipeline, opts)
+}
+
+func (ts *TokenStore) watchChangeStream(ctx context.Context, consumer string, cs *mongo.ChangeStream, ch chan<- RevocationEvent) {
+	defer close(ch)
+
+	for {
+		for cs.Next(ctx) {
+			var change struct {
+				DocumentKey struct {
+					ID string `bson:"_id"`
+				} `bson:"documentKey"`
+				Ns struct {
+					Coll string `bson:"coll"`
+				} `bson:"ns"`
+				ClusterTime primitive.Timestamp `bson:"clusterTime"`
+			}
+			if err := cs.Decode(&change); err != nil {
+				ts.tcfg.storeConfig.log().Error("Err decoding change event", "error", err)
+				continue
+			}
+
+			event := RevocationEvent{
+				TokenType: ts.tokenType(change.Ns.Coll),
+				Token:     change.DocumentKey.ID,
+				RemovedAt: time.Unix(int64(change.ClusterTime.T), 0),
+			}
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				cs.Close(context.Background())
+				return
+			}
+
+			ts.saveCheckpoint(ctx, consumer, bson.M{"ResumeToken": cs.ResumeToken()})
+		}
+
+		// the position is kept in memory, a watcher without consumer has no checkpoint
+		resumeToken := cs.ResumeToken()
+		err := cs.Err()
+		cs.Close(context.Background())
+		if ctx.Err() != nil {
+			return
+		}
+		ts.tcfg.storeConfig.log().Warn("Revocations change stream interrupted", "error", err)
+
+		// reopen the stream where it stopped
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchRetryDelay):
+			}
+			cs, err = ts.openRevocationStream(ctx, consumer, resumeToken)
+			if err == nil {
+				break
+			}
+			ts.tcfg.storeConfig.log().Error("Err reopening revocations change stream", "error", err)
+		}
+	}
+}
+
+// recordRevocation write the tombstone read by the polling mode
+func (ts *TokenStore) recordRevocation(ctx context.Context, collection, token string) {
+	if !ts.pollingEnabled() {
+		return
+	}
+
+	_, err := ts.c(ctx, ts.tcfg.RevocationsCName, ts.tcfg.storeConfig.writeOptions(WriteTokenRevoke)).InsertOne(ctx, revocationData{
+		ID:        primitive.NewObjectID(),
+		TokenType: ts.tokenType(collection),
+		Token:     token,
+		RevokedAt: time.Now(),
+	})
+	if err != nil {
+		ts.tcfg.storeConfig.log().Error("Err recording revocation", "collection", ts.tcfg.RevocationsCName, "error", err)
+	}
+}
+
+// startPollWindow return the window of the first poll, from the checkpoint of the consumer if any
+// else from now on: the tombstones already written in the window are marked delivered
+func (ts *TokenStore) startPollWindow(ctx context.Context, consumer string) (*pollWindow, error) {
+	cp, err := ts.loadCheckpoint(ctx, consumer)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil && !cp.LastID.IsZero() {
+		return newPollWindow(cp.LastID), nil
+	}
+
+	window := newPollWindow(primitive.NewObjectIDFromTimestamp(time.Now()))
+	cursor, err := ts.c(ctx, ts.tcfg.RevocationsCName).Find(ctx,
+		bson.M{"_id": bson.M{"$gt": window.floor()}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var revocations []revocationData
+	if err = cursor.All(ctx, &revocations); err != nil {
+		return nil, err
+	}
+	for _, r := range revocations {
+		window.add(r.ID)
+	}
+	return window, nil
+}
+
+func (ts *TokenStore) pollRevocations(ctx context.Context, consumer string, window *pollWindow, ch chan<- RevocationEvent) {
+	defer close(ch)
+
+	ticker := time.NewTicker(ts.tcfg.storeConfig.revocationPolling)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cursor, err := ts.c(ctx, ts.tcfg.RevocationsCName).Find(ctx,
+			bson.M{"_id": bson.M{"$gt": window.floor()}},
+			options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+		)
+		if err != nil {
+			ts.tcfg.storeConfig.log().Error("Err polling revocations", "collection", ts.tcfg.RevocationsCName, "error", err)
+			continue
+		}
+
+		var revocations []revocationData
+		if err := cursor.All(ctx, &revocations); err != nil {
+			ts.tcfg.storeConfig.log().Error("Err polling revocations", "collection", ts.tcfg.RevocationsCName, "error", err)
+			continue
+		}
+
+		delivered := false
+		for _, r := range revocations {
+			if !window.add(r.ID) {
+				continue
+			}
+			select {
+			case ch <- RevocationEvent{TokenType: r.TokenType, Token: r.Token, RemovedAt: r.RevokedAt}:
+			case <-ctx.Done():
+				return
+			}
+			delivered = true
+		}
+
+		if delivered {
+			ts.saveCheckpoint(ctx, consumer, bson.M{"LastID": window.lastID})
+		}
+		window.prune()
+	}
+}
+
+// pollWindow deliver each tombstone once, their ids are generated by the writers and a tombstone
+// can be inserted behind the last one delivered when the clocks of the instances drift apart
+// a watcher resuming from a checkpoint delivers again the tombstones of the window
+type pollWindow struct {
+	lastID primitive.ObjectID
+	seen   map[primitive.ObjectID]struct{}
+}
+
+func newPollWindow(lastID primitive.ObjectID) *pollWindow {
+	return &pollWindow{lastID: lastID, seen: make(map[primitive.ObjectID]struct{})}
+}
+
+// floor return the id the poll reads from, revocationSkew before the last one delivered
+func (w *pollWindow) floor() primitive.ObjectID {
+	return primitive.NewObjectIDFromTimestamp(w.lastID.Timestamp().Add(-revocationSkew))
+}
+
+// add record the tombstone, false when it has already been delivered
+func (w *pollWindow) add(id primitive.ObjectID) bool {
+	if _, ok := w.seen[id]; ok {
+		return false
+	}
+	w.seen[id] = struct{}{}
+	if bytes.Compare(id[:], w.lastID[:]) > 0 {
+		w.lastID = id
+	}
+	return true
+}
+
+// prune forget the tombstones below the floor, they are not read anymore
+func (w *pollWindow) prune() {
+	floor := w.floor()
+	for id := range w.seen {
+		if bytes.Compare(id[:], floor[:]) <= 0 {
+			delete(w.seen, id)
+		}
+	}
+}
+
+// createRevocationsIndex expire the tombstones of the polling mode
+func (ts *TokenStore) createRevocationsIndex(ctx context.Context) error {
+	_, err := ts.c(ctx, ts.tcfg.RevocationsCName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "RevokedAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(revocationRetention / time.Second)),
+	})
+	return err
+}
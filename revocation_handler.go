@@ -0,0 +1,83 @@
+package mongo
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// refreshRevoker is implemented by token stores able to revoke a refresh token with its access tokens
+type refreshRevoker interface {
+	RevokeByRefresh(ctx context.Context, refresh string) error
+}
+
+// RevocationHandler implements the RFC 7009 token revocation endpoint
+// a client can only revoke its own tokens, revoking a refresh token also revokes its access tokens
+type RevocationHandler struct {
+	tokenStore  oauth2.TokenStore
+	clientStore oauth2.ClientStore
+}
+
+// NewRevocationHandler create the revocation endpoint handler
+func NewRevocationHandler(ts oauth2.TokenStore, cs oauth2.ClientStore) *RevocationHandler {
+	return &RevocationHandler{
+		tokenStore:  ts,
+		clientStore: cs,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (rh *RevocationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, endpointError{Error: "invalid_request"})
+		return
+	}
+
+	cli, err := authenticateClient(r, rh.clientStore)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeJSON(w, http.StatusBadRequest, endpointError{Error: "invalid_request", ErrorDescription: "missing token"})
+		return
+	}
+
+	ti, tokenType, err := lookupToken(r.Context(), rh.tokenStore, token, r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, endpointError{Error: "temporarily_unavailable"})
+		return
+	}
+
+	// an invalid or unknown token is not an error
+	if ti == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if ti.GetClientID() != cli.GetID() {
+		writeJSON(w, http.StatusBadRequest, endpointError{Error: "unauthorized_client", ErrorDescription: "the token was not issued to this client"})
+		return
+	}
+
+	if tokenType == tokenTypeHintAccess {
+		err = rh.tokenStore.RemoveByAccess(r.Context(), token)
+	} else if revoker, ok := rh.tokenStore.(refreshRevoker); ok {
+		err = revoker.RevokeByRefresh(r.Context(), token)
+	} else {
+		err = rh.tokenStore.RemoveByRefresh(r.Context(), token)
+		if err == nil && ti.GetAccess() != "" {
+			err = rh.tokenStore.RemoveByAccess(r.Context(), ti.GetAccess())
+		}
+	}
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, endpointError{Error: "temporarily_unavailable"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
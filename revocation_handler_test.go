@@ -0,0 +1,68 @@
+package mongo
+
+import (
+	"net/http"
+	neturl "net/url"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRevocationHandler(t *testing.T) {
+	Convey("Test revocation handler", t, func() {
+		info := &models.Token{
+			ClientID:         "app",
+			UserID:           "user",
+			Access:           "access_token",
+			AccessCreateAt:   time.Now(),
+			AccessExpiresIn:  time.Hour,
+			Refresh:          "refresh_token",
+			RefreshCreateAt:  time.Now(),
+			RefreshExpiresIn: 2 * time.Hour,
+		}
+		clients := mockClientStore{
+			"app":   &models.Client{ID: "app", Secret: "app_secret"},
+			"other": &models.Client{ID: "other", Secret: "other_secret"},
+		}
+		tokens := newMockTokenStore(info)
+		handler := NewRevocationHandler(tokens, clients)
+
+		Convey("AccessToken", func() {
+			rec := postForm(handler, neturl.Values{"token": {"access_token"}}, "app", "app_secret")
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(tokens.access, ShouldNotContainKey, "access_token")
+			So(tokens.refresh, ShouldContainKey, "refresh_token")
+		})
+
+		Convey("RefreshTokenCascade", func() {
+			rec := postForm(handler, neturl.Values{"token": {"refresh_token"}, "token_type_hint": {"refresh_token"}}, "app", "app_secret")
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(tokens.refresh, ShouldNotContainKey, "refresh_token")
+			So(tokens.access, ShouldNotContainKey, "access_token")
+		})
+
+		Convey("OtherClientToken", func() {
+			rec := postForm(handler, neturl.Values{"token": {"access_token"}}, "other", "other_secret")
+
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(tokens.access, ShouldContainKey, "access_token")
+		})
+
+		Convey("UnknownToken", func() {
+			rec := postForm(handler, neturl.Values{"token": {"unknown"}}, "app", "app_secret")
+
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("InvalidClient", func() {
+			rec := postForm(handler, neturl.Values{"token": {"access_token"}}, "app", "wrong")
+
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+			So(tokens.access, ShouldContainKey, "access_token")
+		})
+	})
+}
@@ -0,0 +1,116 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWatchRevocationsUnavailable(t *testing.T) {
+	Convey("Test single node without polling", t, func() {
+		ts := &TokenStore{tcfg: NewDefaultTokenConfig(NewDefaultStoreConfig(dbName, service, false))}
+
+		ch, err := ts.WatchRevocations(context.TODO())
+
+		So(ch, ShouldBeNil)
+		So(err, ShouldEqual, ErrRevocationWatchUnavailable)
+	})
+}
+
+func TestPollWindow(t *testing.T) {
+	Convey("Test the polling window", t, func() {
+		now := time.Now()
+		last := primitive.NewObjectIDFromTimestamp(now)
+		window := newPollWindow(last)
+		So(window.floor().Timestamp(), ShouldEqual, now.Add(-revocationSkew).Truncate(time.Second))
+
+		// a tombstone written by an instance whose clock is late is still delivered, once
+		late := primitive.NewObjectIDFromTimestamp(now.Add(-10 * time.Second))
+		next := primitive.NewObjectIDFromTimestamp(now.Add(time.Second))
+		So(window.add(next), ShouldBeTrue)
+		So(window.add(late), ShouldBeTrue)
+		So(window.add(late), ShouldBeFalse)
+		So(window.lastID, ShouldEqual, next)
+
+		window.lastID = primitive.NewObjectIDFromTimestamp(now.Add(2 * revocationSkew))
+		window.prune()
+		So(len(window.seen), ShouldEqual, 0)
+	})
+}
+
+func TestWatchRevocations(t *testing.T) {
+	Convey("Test watch revocations", t, func() {
+		storeConfig := NewStoreConfig(1, 5).SetRevocationPolling(100 * time.Millisecond).SetRevocationConsumer("test")
+
+		var store *TokenStore
+		if !isReplicaSet {
+			store = NewTokenStore(NewConfigNonReplicaSet(url, dbName, username, password, service), storeConfig)
+		} else {
+			store = NewTokenStore(NewConfigReplicaSet(url, dbName), storeConfig)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		events, err := store.WatchRevocations(ctx)
+		So(err, ShouldBeNil)
+
+		info := &models.Token{
+			ClientID:        "1",
+			UserID:          "1_3",
+			Access:          "1_3_1",
+			AccessCreateAt:  time.Now(),
+			AccessExpiresIn: time.Second * 5,
+		}
+		So(store.Create(context.TODO(), info), ShouldBeNil)
+		So(store.RemoveByAccess(context.TODO(), info.Access), ShouldBeNil)
+
+		select {
+		case event := <-events:
+			So(event.TokenType, ShouldEqual, "access")
+			So(event.Token, ShouldEqual, info.Access)
+		case <-time.After(5 * time.Second):
+			So("no revocation event received", ShouldBeEmpty)
+		}
+
+		cancel()
+		for range events {
+		}
+	})
+}
+
+func TestWatchRevocationsFromNow(t *testing.T) {
+	Convey("Test watch revocations without consumer", t, func() {
+		if isReplicaSet {
+			return
+		}
+		storeConfig := NewStoreConfig(1, 5).SetRevocationPolling(100 * time.Millisecond)
+		store := NewTokenStore(NewConfigNonReplicaSet(url, dbName, username, password, service), storeConfig)
+
+		// revoked before the watcher started
+		store.recordRevocation(context.TODO(), store.tcfg.AccessCName, "1_3_2")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events, err := store.WatchRevocations(ctx)
+		So(err, ShouldBeNil)
+
+		store.recordRevocation(context.TODO(), store.tcfg.AccessCName, "1_3_3")
+
+		select {
+		case event := <-events:
+			So(event.Token, ShouldEqual, "1_3_3")
+		case <-time.After(5 * time.Second):
+			So("no revocation event received", ShouldBeEmpty)
+		}
+
+		cancel()
+		for range events {
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ShardKey is the shard key of a collection, always on _id: every lookup and removal by token, code or client id
+// filters on _id, so they are routed to a single shard instead of all of them
+// the lookups by user, client or BasicID of the revocations are not covered and query all the shards,
+// a compound key would cover them at the cost of sending every token lookup to all the shards
+type ShardKey string
+
+const (
+	// ShardKeyHashed {_id: "hashed"}, spreads the random token values and ids evenly
+	ShardKeyHashed ShardKey = "hashed"
+	// ShardKeyRanged {_id: 1}, for ids with a meaningful order
+	ShardKeyRanged ShardKey = "ranged"
+)
+
+func (k ShardKey) keys() (bson.D, error) {
+	switch k {
+	case ShardKeyHashed:
+		return bson.D{{Key: "_id", Value: "hashed"}}, nil
+	case ShardKeyRanged:
+		return bson.D{{Key: "_id", Value: 1}}, nil
+	}
+	return nil, fmt.Errorf("unsupported shard key %q", string(k))
+}
+
+// ShardConfig shard keys of the token collections, an empty key leaves the collection unsharded
+type ShardConfig struct {
+	Basic   ShardKey
+	Access  ShardKey
+	Refresh ShardKey
+}
+
+// NewDefaultShardConfig create a configuration sharding the token collections on their hashed _id
+func NewDefaultShardConfig() *ShardConfig {
+	return &ShardConfig{
+		Basic:   ShardKeyHashed,
+		Access:  ShardKeyHashed,
+		Refresh: ShardKeyHashed,
+	}
+}
+
+// ShardCollections enable the sharding of the database and shard the token collections,
+// through the mongos of a sharded cluster, in the tenant database of ctx for a multi-tenant store
+// it can be run again, the collections already sharded with the same key are left as they are
+func (ts *TokenStore) ShardCollections(ctx context.Context, shcfg *ShardConfig) error {
+	if err := ts.tenant(ctx); err != nil {
+		return err
+	}
+	if shcfg == nil {
+		shcfg = NewDefaultShardConfig()
+	}
+
+	return shardCollections(ctx, ts.client, ts.tcfg.storeConfig, map[string]ShardKey{
+		ts.tcfg.BasicCName:   shcfg.Basic,
+		ts.tcfg.AccessCName:  shcfg.Access,
+		ts.tcfg.RefreshCName: shcfg.Refresh,
+	})
+}
+
+// ShardCollections enable the sharding of the database and shard the clients collection,
+// through the mongos of a sharded cluster, in the tenant database of ctx for a multi-tenant store
+func (cs *ClientStore) ShardCollections(ctx context.Context, key ShardKey) error {
+	if err := cs.ccfg.storeConfig.checkTenant(ctx); err != nil {
+		return err
+	}
+
+	return shardCollections(ctx, cs.client, cs.ccfg.storeConfig, map[string]ShardKey{
+		cs.ccfg.ClientsCName: key,
+	})
+}
+
+func shardCollections(ctx context.Context, client *mongo.Client, sc *StoreConfig, keys map[string]ShardKey) error {
+	db := sc.dbName(ctx)
+	admin := client.Database("admin")
+
+	if err := admin.RunCommand(ctx, bson.D{{Key: "enableSharding", Value: db}}).Err(); err != nil {
+		sc.log().Error("Error enabling sharding", "error", err)
+		return err
+	}
+
+	for cname, key := range keys {
+		if key == "" {
+			continue
+		}
+		shardKey, err := key.keys()
+		if err != nil {
+			return err
+		}
+
+		// a non empty collection needs the index of the shard key, the ranged one is the _id index
+		if key == ShardKeyHashed {
+			_, err = client.Database(db).Collection(cname).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: shardKey})
+			if err != nil {
+				sc.log().Error("Error creating index", "collection", cname, "error", err)
+				return err
+			}
+		}
+
+		err = admin.RunCommand(ctx, bson.D{
+			{Key: "shardCollection", Value: db + "." + cname},
+			{Key: "key", Value: shardKey},
+		}).Err()
+		if err != nil {
+			sc.log().Error("Error sharding collection", "collection", cname, "error", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package mongo
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShardKey(t *testing.T) {
+	Convey("Test shard keys", t, func() {
+		keys, err := ShardKeyHashed.keys()
+		So(err, ShouldBeNil)
+		So(keys, ShouldResemble, bson.D{{Key: "_id", Value: "hashed"}})
+
+		keys, err = ShardKeyRanged.keys()
+		So(err, ShouldBeNil)
+		So(keys, ShouldResemble, bson.D{{Key: "_id", Value: 1}})
+
+		_, err = ShardKey("UserID").keys()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test sharded configuration", t, func() {
+		cfg := NewConfigSharded("mongodb://mongos:27017", dbName, username, password, service)
+		So(cfg.IsReplicaSet, ShouldBeTrue)
+		So(cfg.IsSharded, ShouldBeTrue)
+
+		sc := NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet)
+		sc.isSharded = cfg.IsSharded
+		So(sc.deploymentMode(), ShouldEqual, "sharded")
+	})
+}
@@ -0,0 +1,88 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionStats is the size of a collection, summed over the shards of a sharded cluster
+type CollectionStats struct {
+	Name           string `json:"name"`
+	Count          int64  `json:"count"`
+	Size           int64  `json:"size"`
+	StorageSize    int64  `json:"storage_size"`
+	Indexes        int64  `json:"indexes"`
+	TotalIndexSize int64  `json:"total_index_size"`
+}
+
+// Stats return the statistics of the token collections, in the tenant database of ctx for a multi-tenant store
+func (ts *TokenStore) Stats(ctx context.Context) ([]*CollectionStats, error) {
+	if err := ts.tenant(ctx); err != nil {
+		return nil, err
+	}
+
+	var stats []*CollectionStats
+	for _, cname := range []string{ts.tcfg.BasicCName, ts.tcfg.AccessCName, ts.tcfg.RefreshCName, ts.tcfg.TxnCName} {
+		cs, err := collectionStats(ctx, ts.c(ctx, cname))
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, cs)
+	}
+	return stats, nil
+}
+
+// Stats return the statistics of the clients collection, in the tenant database of ctx for a multi-tenant store
+func (cs *ClientStore) Stats(ctx context.Context) ([]*CollectionStats, error) {
+	if err := cs.ccfg.storeConfig.checkTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	stats, err := collectionStats(ctx, cs.c(ctx, cs.ccfg.ClientsCName))
+	if err != nil {
+		return nil, err
+	}
+	return []*CollectionStats{stats}, nil
+}
+
+// collectionStats read the storage statistics of the collection, zero for a collection not created yet
+func collectionStats(ctx context.Context, coll *mongo.Collection) (*CollectionStats, error) {
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$collStats", Value: bson.M{"storageStats": bson.M{}}}},
+	})
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFoundCode {
+			return &CollectionStats{Name: coll.Name()}, nil
+		}
+		return nil, err
+	}
+
+	var results []struct {
+		StorageStats struct {
+			Count          int64 `bson:"count"`
+			Size           int64 `bson:"size"`
+			StorageSize    int64 `bson:"storageSize"`
+			Indexes        int64 `bson:"nindexes"`
+			TotalIndexSize int64 `bson:"totalIndexSize"`
+		} `bson:"storageStats"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	// $collStats return one result per shard, the sizes are summed over the shards
+	// but the shards hold the same indexes, so their count is not
+	stats := &CollectionStats{Name: coll.Name()}
+	for _, r := range results {
+		stats.Count += r.StorageStats.Count
+		stats.Size += r.StorageStats.Size
+		stats.StorageSize += r.StorageStats.StorageSize
+		stats.Indexes = r.StorageStats.Indexes
+		stats.TotalIndexSize += r.StorageStats.TotalIndexSize
+	}
+	return stats, nil
+}
@@ -0,0 +1,105 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+)
+
+var (
+	// ErrTenantRequired the store is multi-tenant and the context holds no tenant
+	ErrTenantRequired = errors.New("tenant required in context, use WithTenant")
+	// ErrInvalidTenant the tenant id is not made of 1 to 32 letters, digits, '-' or '_'
+	ErrInvalidTenant = errors.New("invalid tenant id")
+)
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+type tenantKey struct{}
+
+// WithTenant return a context bound to the tenant, to pass to the stores methods
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext return the tenant bound to the context, empty if none
+func TenantFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// SetMultiTenant isolate the tenants in their own database, named <db>_<tenant>,
+// the tenant is read from the context of each call, a call without tenant fails with ErrTenantRequired
+func (sc *StoreConfig) SetMultiTenant(enabled bool) *StoreConfig {
+	sc.multiTenant = enabled
+	return sc
+}
+
+// checkTenant verify the context holds a valid tenant when the store is multi-tenant
+func (sc *StoreConfig) checkTenant(ctx context.Context) error {
+	if !sc.multiTenant {
+		return nil
+	}
+	tenant := TenantFromContext(ctx)
+	if tenant == "" {
+		return ErrTenantRequired
+	}
+	if !tenantPattern.MatchString(tenant) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// dbName return the database of the tenant bound to the context
+// without a valid tenant, a multi-tenant store gets an invalid empty name so that nothing can be read or written
+func (sc *StoreConfig) dbName(ctx context.Context) string {
+	if !sc.multiTenant {
+		return sc.db
+	}
+	if sc.checkTenant(ctx) != nil {
+		return ""
+	}
+	return sc.db + "_" + TenantFromContext(ctx)
+}
+
+// tenantSetup run a setup, the index creations, once per tenant
+// the tenants are set up independently, a failed setup is run again by the next call of the tenant
+type tenantSetup struct {
+	mu      sync.Mutex
+	tenants map[string]*tenantOnce
+}
+
+type tenantOnce struct {
+	mu   sync.Mutex
+	done bool
+}
+
+func (t *tenantSetup) ensure(ctx context.Context, setup func(ctx context.Context) error) error {
+	tenant := TenantFromContext(ctx)
+
+	t.mu.Lock()
+	if t.tenants == nil {
+		t.tenants = map[string]*tenantOnce{}
+	}
+	once, ok := t.tenants[tenant]
+	if !ok {
+		once = &tenantOnce{}
+		t.tenants[tenant] = once
+	}
+	t.mu.Unlock()
+
+	once.mu.Lock()
+	defer once.mu.Unlock()
+	if once.done {
+		return nil
+	}
+	if err := setup(ctx); err != nil {
+		return err
+	}
+	once.done = true
+	return nil
+}
@@ -0,0 +1,147 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTenantContext(t *testing.T) {
+	Convey("Test tenant resolution", t, func() {
+		sc := NewDefaultStoreConfig(dbName, service, false)
+
+		Convey("Test single tenant", func() {
+			So(sc.checkTenant(context.TODO()), ShouldBeNil)
+			So(sc.dbName(WithTenant(context.TODO(), "acme")), ShouldEqual, dbName)
+		})
+
+		Convey("Test multi-tenant", func() {
+			sc.SetMultiTenant(true)
+
+			So(sc.checkTenant(context.TODO()), ShouldEqual, ErrTenantRequired)
+			So(sc.dbName(context.TODO()), ShouldEqual, "")
+
+			So(sc.checkTenant(WithTenant(context.TODO(), "acme/../other")), ShouldEqual, ErrInvalidTenant)
+			So(sc.dbName(WithTenant(context.TODO(), "acme/../other")), ShouldEqual, "")
+
+			ctx := WithTenant(context.TODO(), "acme")
+			So(sc.checkTenant(ctx), ShouldBeNil)
+			So(sc.dbName(ctx), ShouldEqual, dbName+"_acme")
+
+			// the request timeout context keeps the tenant
+			ctxReq, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			So(TenantFromContext(withSpan(ctxReq, ctx)), ShouldEqual, "acme")
+		})
+	})
+}
+
+func TestTenantSetup(t *testing.T) {
+	Convey("Test tenant setup", t, func() {
+		var ts tenantSetup
+		acme := WithTenant(context.TODO(), "acme")
+		other := WithTenant(context.TODO(), "other")
+
+		Convey("Test a failed setup is run again", func() {
+			errSetup := errors.New("setup failed")
+			calls := 0
+			setup := func(ctx context.Context) error {
+				calls++
+				if calls == 1 {
+					return errSetup
+				}
+				return nil
+			}
+			So(ts.ensure(acme, setup), ShouldEqual, errSetup)
+			So(ts.ensure(acme, setup), ShouldBeNil)
+			So(ts.ensure(acme, setup), ShouldBeNil)
+			So(calls, ShouldEqual, 2)
+		})
+
+		Convey("Test a slow tenant does not hold the others", func() {
+			started, release := make(chan struct{}), make(chan struct{})
+			go ts.ensure(acme, func(ctx context.Context) error {
+				close(started)
+				<-release
+				return nil
+			})
+			<-started
+			defer close(release)
+
+			done := make(chan error, 1)
+			go func() { done <- ts.ensure(other, func(ctx context.Context) error { return nil }) }()
+			select {
+			case err := <-done:
+				So(err, ShouldBeNil)
+			case <-time.After(time.Second):
+				So("setup of other blocked", ShouldBeEmpty)
+			}
+		})
+	})
+}
+
+func TestTokenStoreMultiTenant(t *testing.T) {
+	Convey("Test multi-tenant token and client stores", t, func() {
+		storeConfig := NewStoreConfig(0, 0).SetMultiTenant(true)
+
+		var cfg *Config
+		if !isReplicaSet {
+			cfg = NewConfigNonReplicaSet(url, dbName, username, password, service)
+		} else {
+			cfg = NewConfigReplicaSet(url, dbName)
+		}
+
+		store := NewTokenStore(cfg, storeConfig)
+		clientStore := NewClientStoreWithSession(store.client, cfg, storeConfig)
+
+		acme := WithTenant(context.TODO(), "acme")
+		globex := WithTenant(context.TODO(), "globex")
+		defer store.client.Database(dbName + "_acme").Drop(context.TODO())
+		defer store.client.Database(dbName + "_globex").Drop(context.TODO())
+
+		token := &models.Token{
+			ClientID:        "tenant_client",
+			UserID:          "tenant_user",
+			Scope:           "all",
+			Access:          "tenant_access",
+			AccessCreateAt:  time.Now(),
+			AccessExpiresIn: time.Second * 5,
+		}
+
+		err := store.Create(context.TODO(), token)
+		So(err, ShouldEqual, ErrTenantRequired)
+
+		err = store.Create(acme, token)
+		So(err, ShouldBeNil)
+
+		ainfo, err := store.GetByAccess(acme, token.Access)
+		So(err, ShouldBeNil)
+		So(ainfo.GetUserID(), ShouldEqual, token.UserID)
+
+		// the token is not visible from another tenant
+		_, err = store.GetByAccess(globex, token.Access)
+		So(err.Error(), ShouldEqual, "mongo: no documents in result")
+
+		_, err = store.GetByAccess(context.TODO(), token.Access)
+		So(err, ShouldEqual, ErrTenantRequired)
+
+		client := &models.Client{ID: "tenant_client", Secret: "secret"}
+		err = clientStore.Create(client)
+		So(err, ShouldEqual, ErrTenantRequired)
+
+		err = clientStore.CreateWithContext(acme, client)
+		So(err, ShouldBeNil)
+
+		_, err = clientStore.GetByID(globex, client.ID)
+		So(err.Error(), ShouldEqual, "mongo: no documents in result")
+
+		cinfo, err := clientStore.GetByID(acme, client.ID)
+		So(err, ShouldBeNil)
+		So(cinfo.GetSecret(), ShouldEqual, client.Secret)
+	})
+}
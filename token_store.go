@@ -3,7 +3,7 @@ package mongo
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
 	"time"
 
 	"github.com/go-oauth2/oauth2/v4"
@@ -25,21 +25,28 @@ type TokenConfig struct {
 	AccessCName string
 	// store refresh token data collection name(The default is oauth2_refresh)
 	RefreshCName string
-	storeConfig  *StoreConfig
+	// store revoked tokens collection name, used by the revocations polling mode(The default is oauth2_revocations)
+	RevocationsCName string
+	// store watchers checkpoints collection name(The default is oauth2_checkpoints)
+	CheckpointsCName string
+	storeConfig      *StoreConfig
 }
 
 // NewDefaultTokenConfig create a default token configuration
 func NewDefaultTokenConfig(strConfig *StoreConfig) *TokenConfig {
 	return &TokenConfig{
-		TxnCName:     "oauth2_txn",
-		BasicCName:   "oauth2_basic",
-		AccessCName:  "oauth2_access",
-		RefreshCName: "oauth2_refresh",
-		storeConfig:  strConfig,
+		TxnCName:         "oauth2_txn",
+		BasicCName:       "oauth2_basic",
+		AccessCName:      "oauth2_access",
+		RefreshCName:     "oauth2_refresh",
+		RevocationsCName: "oauth2_revocations",
+		CheckpointsCName: "oauth2_checkpoints",
+		storeConfig:      strConfig,
 	}
 }
 
 // NewTokenStore create a token store instance based on mongodb
+// it panics if mongo can not be reached
 func NewTokenStore(cfg *Config, scfgs ...*StoreConfig) (store *TokenStore) {
 	clientOptions := options.Client().ApplyURI(cfg.URL)
 	ctx := context.TODO()
@@ -59,26 +66,29 @@ func NewTokenStore(cfg *Config, scfgs ...*StoreConfig) (store *TokenStore) {
 		clientOptions.SetConnectTimeout(time.Duration(scfgs[0].requestTimeout) * time.Second)
 	}
 
-	if !cfg.IsReplicaSet {
+	if !cfg.IsReplicaSet || cfg.IsSharded {
 		clientOptions.SetAuth(options.Credential{
 			Username: cfg.Username,
 			Password: cfg.Password,
 		})
 	}
 
+	logger := storeLogger(scfgs...)
+
 	c, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.Fatal("ClientStore failed to connect mongo: ", err)
-	} else {
-		log.Println("Connection to mongoDB successful")
+		logger.Error("TokenStore failed to connect mongo", "error", err)
+		panic(err)
 	}
+	logger.Info("Connection to mongoDB successful")
 
 	err = c.Ping(ctxPing, nil)
 	if err != nil {
-		log.Fatal("MongoDB ping failed:", err)
+		logger.Error("MongoDB ping failed", "error", err)
+		panic(err)
 	}
 
-	log.Println("Ping db successfull")
+	logger.Debug("Ping db successful")
 
 	return NewTokenStoreWithSession(c, cfg, scfgs...)
 }
@@ -86,58 +96,109 @@ func NewTokenStore(cfg *Config, scfgs ...*StoreConfig) (store *TokenStore) {
 // NewTokenStoreWithSession create a token store instance based on mongodb
 func NewTokenStoreWithSession(client *mongo.Client, cfg *Config, scfgs ...*StoreConfig) (store *TokenStore) {
 	strCfgs := NewDefaultStoreConfig(cfg.DB, cfg.Service, cfg.IsReplicaSet)
+	strCfgs.isSharded = cfg.IsSharded
 
 	ts := &TokenStore{
 		client: client,
 		tcfg:   NewDefaultTokenConfig(strCfgs),
 	}
 
-	if len(scfgs) > 0 {
-		if scfgs[0].connectionTimeout > 0 {
-			ts.tcfg.storeConfig.connectionTimeout = scfgs[0].connectionTimeout
+	ts.tcfg.storeConfig.override(scfgs...)
+
+	if !ts.tcfg.storeConfig.isReplicaSet {
+		ts.txnHandler = NewTransactionHandler(client, ts.tcfg)
+		if tw, ok := ts.txnHandler.tw.(*transactionWorker); ok {
+			ts.tcfg.storeConfig.metrics.watchJournal(tw)
 		}
-		if scfgs[0].requestTimeout > 0 {
-			ts.tcfg.storeConfig.requestTimeout = scfgs[0].requestTimeout
+	}
+
+	// a multi-tenant store sets up each tenant database on its first call
+	if !ts.tcfg.storeConfig.multiTenant {
+		if err := ts.setup(context.TODO()); err != nil {
+			panic(err)
 		}
 	}
 
-	if !ts.tcfg.storeConfig.isReplicaSet {
-		ts.txnHandler = NewTransactionHandler(client, ts.tcfg)
+	store = ts
+	return
+}
 
+// setup remove the garbage of failed transactions, create the indexes and run the migrations, in the database of ctx
+func (ts *TokenStore) setup(ctx context.Context) error {
+	db := ts.client.Database(ts.tcfg.storeConfig.dbName(ctx))
+
+	if !ts.tcfg.storeConfig.isReplicaSet {
 		// in case transactions did fail, remove garbage records
-		err := ts.txnHandler.tw.cleanupTransactionsData(context.TODO(), cfg.Service)
+		err := ts.txnHandler.tw.cleanupTransactionsData(ctx, ts.tcfg.storeConfig.service)
 		if err != nil {
 			// TODO what to do with that err ??
-			log.Println("Err cleanupTransactionsData failed: ", err)
+			ts.tcfg.storeConfig.log().Error("Err cleanupTransactionsData failed", "operation", "cleanup", "collection", ts.tcfg.TxnCName, "error", err)
 		}
 	}
 
-	_, err := ts.client.Database(ts.tcfg.storeConfig.db).Collection(ts.tcfg.BasicCName).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
-		Keys:    bson.D{{"ExpiredAt", 1}},
-		Options: options.Index().SetExpireAfterSeconds(1),
-	})
-	if err != nil {
-		log.Fatalln("Error creating index: ", ts.tcfg.BasicCName, " - ", err)
+	for _, cname := range []string{ts.tcfg.BasicCName, ts.tcfg.AccessCName, ts.tcfg.RefreshCName} {
+		_, err := db.Collection(cname).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "ExpiredAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(1),
+		})
+		if err != nil {
+			ts.tcfg.storeConfig.log().Error("Error creating index", "collection", cname, "error", err)
+			return err
+		}
 	}
 
-	_, err = ts.client.Database(ts.tcfg.storeConfig.db).Collection(ts.tcfg.AccessCName).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
-		Keys:    bson.D{{"ExpiredAt", 1}},
-		Options: options.Index().SetExpireAfterSeconds(1),
-	})
-	if err != nil {
-		log.Fatalln("Error creating index: ", ts.tcfg.AccessCName, " - ", err)
+	// lookup of the tokens sharing a token data, see revokeBasic
+	for _, cname := range []string{ts.tcfg.AccessCName, ts.tcfg.RefreshCName} {
+		_, err := db.Collection(cname).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "BasicID", Value: 1}},
+		})
+		if err != nil {
+			ts.tcfg.storeConfig.log().Error("Error creating index", "collection", cname, "error", err)
+			return err
+		}
 	}
 
-	_, err = ts.client.Database(ts.tcfg.storeConfig.db).Collection(ts.tcfg.RefreshCName).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
-		Keys:    bson.D{{"ExpiredAt", 1}},
-		Options: options.Index().SetExpireAfterSeconds(1),
+	// lookup of the token data of a user and a client, or of a client, see RevokeByUserClient
+	_, err := db.Collection(ts.tcfg.BasicCName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "UserID", Value: 1}, {Key: "ClientID", Value: 1}}},
+		{Keys: bson.D{{Key: "ClientID", Value: 1}}},
+		// lookup of the tokens issued from a replayed code, see SetCodeSingleUse
+		{
+			Keys:    bson.D{{Key: "GrantID", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"GrantID": bson.M{"$exists": true}}),
+		},
 	})
 	if err != nil {
-		log.Fatalln("Error creating index: ", ts.tcfg.RefreshCName, " - ", err)
+		ts.tcfg.storeConfig.log().Error("Error creating index", "collection", ts.tcfg.BasicCName, "error", err)
+		return err
 	}
 
-	store = ts
-	return
+	if ts.pollingEnabled() {
+		err = ts.createRevocationsIndex(ctx)
+		if err != nil {
+			ts.tcfg.storeConfig.log().Error("Error creating index", "collection", ts.tcfg.RevocationsCName, "error", err)
+			return err
+		}
+	}
+
+	if ts.tcfg.storeConfig.autoMigrate != nil {
+		if err = autoMigrate(ctx, ts.client, ts.tcfg.storeConfig, ts.tcfg); err != nil {
+			ts.tcfg.storeConfig.log().Error("Error migrating", "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// tenant verify the tenant of ctx and set up its database on first use
+func (ts *TokenStore) tenant(ctx context.Context) error {
+	if !ts.tcfg.storeConfig.multiTenant {
+		return nil
+	}
+	if err := ts.tcfg.storeConfig.checkTenant(ctx); err != nil {
+		return err
+	}
+	return ts.tenants.ensure(ctx, ts.setup)
 }
 
 // TokenStore MongoDB storage for OAuth 2.0
@@ -145,30 +206,59 @@ type TokenStore struct {
 	tcfg       *TokenConfig
 	client     *mongo.Client
 	txnHandler *transactionHandler
+	tenants    tenantSetup
 }
 
 // Close close the mongo session
 func (ts *TokenStore) Close() {
+	if ts.txnHandler != nil && !ts.tcfg.storeConfig.multiTenant {
+		ts.tcfg.storeConfig.metrics.unwatchJournal(ts.tcfg.storeConfig.service)
+	}
 	if err := ts.client.Disconnect(context.Background()); err != nil {
-		log.Fatal(err)
+		ts.tcfg.storeConfig.log().Error("TokenStore failed to disconnect mongo", "error", err)
 	}
 }
 
-func (ts *TokenStore) c(name string) *mongo.Collection {
-	return ts.client.Database(ts.tcfg.storeConfig.db).Collection(name)
+// c return the collection in the database of the tenant of ctx, if any
+func (ts *TokenStore) c(ctx context.Context, name string, opts ...*options.CollectionOptions) *mongo.Collection {
+	return ts.client.Database(ts.tcfg.storeConfig.dbName(ctx)).Collection(name, opts...)
 }
 
 // Create create and store the new token information
-func (ts *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) (err error) {
+func (ts *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	call := &TokenCall{Operation: TokenCreate, Info: info}
+	return ts.intercept(ctx, call, func(ctx context.Context, call *TokenCall) error {
+		return ts.create(ctx, call.Info)
+	})
+}
+
+// create store the token information, once the middlewares have run
+func (ts *TokenStore) create(ctx context.Context, info oauth2.TokenInfo) (err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.Create", "insert", ts.tcfg.BasicCName)
+	defer func() { op.end(err) }()
+	ce := codeExchangeFrom(ctx)
+
 	jv, err := json.Marshal(info)
 	if err != nil {
 		return
 	}
+	// the code is the id of its document, the tokens share a generated one
+	basicID := info.GetCode()
+	if basicID == "" {
+		basicID = primitive.NewObjectID().Hex()
+	}
+	var sealed *sealedField
+	if ts.tcfg.storeConfig.kekProvider != nil {
+		if sealed, err = ts.tcfg.storeConfig.seal(ctx, jv, fieldAAD(basicID, "Sealed")); err != nil {
+			return
+		}
+		jv = nil
+	}
 
 	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
 	}
 
 	if code := info.GetCode(); code != "" {
@@ -176,14 +266,22 @@ func (ts *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) (err er
 		basicData := basicData{
 			ID:        code,
 			Data:      jv,
+			Sealed:    sealed,
 			ExpiredAt: info.GetCodeCreateAt().Add(info.GetCodeExpiresIn()),
+			UserID:    info.GetUserID(),
+			ClientID:  info.GetClientID(),
+			// PKCE
+			CodeChallenge:       info.GetCodeChallenge(),
+			CodeChallengeMethod: info.GetCodeChallengeMethod().String(),
 		}
 
-		_, err = ts.c(ts.tcfg.BasicCName).InsertOne(ctx, basicData)
+		_, err = ts.c(ctx, ts.tcfg.BasicCName, ts.tcfg.storeConfig.writeOptions(WriteTokenCreate)).InsertOne(ctx, basicData)
 		if err != nil {
-			log.Println("Error CreateToken with code: ", err)
+			ts.tcfg.storeConfig.log().Error("Error CreateToken with code", "operation", "insert", "collection", ts.tcfg.BasicCName, "error", err)
+			return
 		}
 
+		ts.tcfg.storeConfig.audit(ctx, tokenAuditEvent(AuditCodeIssued, "code", info))
 		return
 	}
 
@@ -196,13 +294,23 @@ func (ts *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) (err er
 		}
 	}
 
-	id := primitive.NewObjectID().Hex()
+	id := basicID
+
+	// the grant of the tokens, the code they are issued from or the one of the refresh token rotated
+	var code, grant string
+	if ce != nil && ts.tcfg.storeConfig.codeSingleUse {
+		code, grant = ce.take()
+	}
 
 	// Create the basicData document
 	basicData := basicData{
 		ID:        id,
 		Data:      jv,
+		Sealed:    sealed,
 		ExpiredAt: rexp,
+		UserID:    info.GetUserID(),
+		ClientID:  info.GetClientID(),
+		GrantID:   grant,
 	}
 
 	// Create the tokenData document for access
@@ -216,19 +324,22 @@ func (ts *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) (err er
 	ctxTxn, cancel := ts.tcfg.storeConfig.setTransactionCreateContext()
 	defer cancel()
 	if ctxTxn != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxTxn, ctx)
 	}
 
 	// MongoDB is deployed as a replicaSet
 	if ts.tcfg.storeConfig.isReplicaSet {
 
-		// Create collections
-		wcMajority := writeconcern.New(writeconcern.WMajority(), writeconcern.WTimeout(2*time.Second))
-		wcMajorityCollectionOpts := options.Collection().SetWriteConcern(wcMajority)
+		// Create collections, with a majority write concern unless one is set
+		wc := ts.tcfg.storeConfig.writeConcern(WriteTokenCreate)
+		if wc == nil {
+			wc = writeconcern.New(writeconcern.WMajority(), writeconcern.WTimeout(2*time.Second))
+		}
+		wcCollectionOpts := options.Collection().SetWriteConcern(wc)
 
-		basicColl := ts.client.Database(ts.tcfg.storeConfig.db).Collection(ts.tcfg.BasicCName, wcMajorityCollectionOpts)
-		accessColl := ts.client.Database(ts.tcfg.storeConfig.db).Collection(ts.tcfg.AccessCName, wcMajorityCollectionOpts)
-		refreshColl := ts.client.Database(ts.tcfg.storeConfig.db).Collection(ts.tcfg.RefreshCName, wcMajorityCollectionOpts)
+		basicColl := ts.client.Database(ts.tcfg.storeConfig.dbName(ctx)).Collection(ts.tcfg.BasicCName, wcCollectionOpts)
+		accessColl := ts.client.Database(ts.tcfg.storeConfig.dbName(ctx)).Collection(ts.tcfg.AccessCName, wcCollectionOpts)
+		refreshColl := ts.client.Database(ts.tcfg.storeConfig.dbName(ctx)).Collection(ts.tcfg.RefreshCName, wcCollectionOpts)
 
 		callback := func(sessCtx mongo.SessionContext) (interface{}, error) {
 			if _, err := basicColl.InsertOne(sessCtx, basicData); err != nil {
@@ -253,80 +364,272 @@ func (ts *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) (err er
 			return nil, nil
 		}
 
-		session, err := ts.client.StartSession()
-		if err != nil {
-			return err
+		// the transaction joins the causally consistent session started for the context, if any,
+		// a session of the caller may be in a transaction already and is left alone
+		session := causalSession(ctx)
+		if session == nil {
+			session, err = ts.client.StartSession()
+			if err != nil {
+				return err
+			}
+			defer session.EndSession(ctx)
 		}
-		defer session.EndSession(ctx)
-		result, err := session.WithTransaction(ctx, callback)
+		_, err = session.WithTransaction(ctx, callback, options.Transaction().SetWriteConcern(wc))
 		if err != nil {
+			ts.tcfg.storeConfig.log().Error("Error CreateToken transaction", "operation", "insert", "collection", ts.tcfg.BasicCName, "error", err)
 			return err
 		}
-		log.Printf("result: %v\n", result)
 
 	} else {
 		// MongoDB is deployed as a single instance
-		return ts.txnHandler.runTransactionCreate(ctx, info, basicData, accessData, id, rexp)
+		err = ts.txnHandler.runTransactionCreate(ctx, info, basicData, accessData, id, rexp)
+		if err != nil {
+			return
+		}
+	}
 
+	ts.tcfg.storeConfig.audit(ctx, tokenAuditEvent(issuedEventType(info), "access", info))
+
+	// tokens issued from a single-use code are revoked if the code is replayed
+	if code != "" {
+		err = ts.linkIssuedTokens(ctx, code, id)
 	}
 	return
 }
 
 // RemoveByCode use the authorization code to delete the token information
-func (ts *TokenStore) RemoveByCode(ctx context.Context, code string) (err error) {
+func (ts *TokenStore) RemoveByCode(ctx context.Context, code string) error {
+	call := &TokenCall{Operation: TokenRemoveByCode, Token: code}
+	return ts.intercept(ctx, call, func(ctx context.Context, call *TokenCall) error {
+		return ts.removeByCode(ctx, call.Token)
+	})
+}
+
+func (ts *TokenStore) removeByCode(ctx context.Context, code string) (err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.RemoveByCode", "delete", ts.tcfg.BasicCName)
+	defer func() { op.end(err) }()
+
 	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
+	}
+
+	ti := ts.auditTokenInfo(ctx, ts.tcfg.BasicCName, code)
+
+	filter := bson.D{{Key: "_id", Value: code}}
+	if ts.tcfg.storeConfig.codeSingleUse {
+		// a consumed code is kept until it expires to detect its replay
+		filter = append(filter, bson.E{Key: "ConsumedAt", Value: bson.M{"$exists": false}})
 	}
 
-	_, err = ts.c(ts.tcfg.BasicCName).DeleteOne(ctx, bson.D{{Key: "_id", Value: code}})
+	res, err := ts.c(ctx, ts.tcfg.BasicCName, ts.tcfg.storeConfig.writeOptions(WriteTokenRevoke)).DeleteOne(ctx, filter)
 	if err != nil {
-		log.Println("Error RemoveByCode: ", err)
+		ts.tcfg.storeConfig.log().Error("Error RemoveByCode", "operation", "delete", "collection", ts.tcfg.BasicCName, "error", err)
+		return
+	}
+
+	if res.DeletedCount > 0 {
+		ts.tcfg.storeConfig.audit(ctx, tokenAuditEvent(AuditCodeConsumed, "code", ti))
 	}
 	return
 }
 
 // RemoveByAccess use the access token to delete the token information
-func (ts *TokenStore) RemoveByAccess(ctx context.Context, access string) (err error) {
+func (ts *TokenStore) RemoveByAccess(ctx context.Context, access string) error {
+	call := &TokenCall{Operation: TokenRemoveByAccess, Token: access}
+	return ts.intercept(ctx, call, func(ctx context.Context, call *TokenCall) error {
+		return ts.removeByAccess(ctx, call.Token)
+	})
+}
+
+func (ts *TokenStore) removeByAccess(ctx context.Context, access string) (err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.RemoveByAccess", "delete", ts.tcfg.AccessCName)
+	defer func() { op.end(err) }()
+
 	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
 	}
 
-	_, err = ts.c(ts.tcfg.AccessCName).DeleteOne(ctx, bson.D{{Key: "_id", Value: access}})
+	ti := ts.auditTokenInfo(ctx, ts.tcfg.AccessCName, access)
+
+	res, err := ts.c(ctx, ts.tcfg.AccessCName, ts.tcfg.storeConfig.writeOptions(WriteTokenRevoke)).DeleteOne(ctx, bson.D{{Key: "_id", Value: access}})
 	if err != nil {
-		log.Println("Error RemoveByAccess: ", err)
+		ts.tcfg.storeConfig.log().Error("Error RemoveByAccess", "operation", "delete", "collection", ts.tcfg.AccessCName, "error", err)
+		return
+	}
+
+	if res.DeletedCount > 0 {
+		ts.recordRevocation(ctx, ts.tcfg.AccessCName, access)
+		ts.tcfg.storeConfig.audit(ctx, tokenAuditEvent(AuditTokenRevoked, "access", ti))
 	}
 	return
 }
 
 // RemoveByRefresh use the refresh token to delete the token information
-func (ts *TokenStore) RemoveByRefresh(ctx context.Context, refresh string) (err error) {
+func (ts *TokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	call := &TokenCall{Operation: TokenRemoveByRefresh, Token: refresh}
+	return ts.intercept(ctx, call, func(ctx context.Context, call *TokenCall) error {
+		return ts.removeByRefresh(ctx, call.Token)
+	})
+}
+
+func (ts *TokenStore) removeByRefresh(ctx context.Context, refresh string) (err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.RemoveByRefresh", "delete", ts.tcfg.RefreshCName)
+	defer func() { op.end(err) }()
+
 	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
 	}
 
-	_, err = ts.c(ts.tcfg.RefreshCName).DeleteOne(ctx, bson.D{{Key: "_id", Value: refresh}})
+	ti := ts.auditTokenInfo(ctx, ts.tcfg.RefreshCName, refresh)
+
+	res, err := ts.c(ctx, ts.tcfg.RefreshCName, ts.tcfg.storeConfig.writeOptions(WriteTokenRevoke)).DeleteOne(ctx, bson.D{{Key: "_id", Value: refresh}})
 	if err != nil {
-		log.Println("Error RemoveByRefresh: ", err)
+		ts.tcfg.storeConfig.log().Error("Error RemoveByRefresh", "operation", "delete", "collection", ts.tcfg.RefreshCName, "error", err)
+		return
+	}
+
+	if res.DeletedCount > 0 {
+		ts.recordRevocation(ctx, ts.tcfg.RefreshCName, refresh)
+		ts.tcfg.storeConfig.audit(ctx, tokenAuditEvent(AuditTokenRevoked, "refresh", ti))
 	}
 	return
 }
 
-func (ts *TokenStore) getData(basicID string) (ti oauth2.TokenInfo, err error) {
-	ctx := context.Background()
+// RevokeByRefresh remove the refresh token, the access tokens sharing its BasicID, then the token data
+func (ts *TokenStore) RevokeByRefresh(ctx context.Context, refresh string) error {
+	if err := ts.tenant(ctx); err != nil {
+		return err
+	}
+
+	basicID, err := ts.getBasicID(ctx, ts.tcfg.RefreshCName, refresh)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ts.revokeBasic(ctx, basicID)
+}
+
+// RevokeByUserClient remove the authorization codes and the tokens issued to the client for the user
+// the token data stored before the UserID and ClientID fields were added are not found
+func (ts *TokenStore) RevokeByUserClient(ctx context.Context, userID, clientID string) error {
+	return ts.revokeMatching(ctx, bson.M{"UserID": userID, "ClientID": clientID})
+}
+
+// RevokeByUser remove the authorization codes and the tokens issued to all the clients for the user
+func (ts *TokenStore) RevokeByUser(ctx context.Context, userID string) error {
+	return ts.revokeMatching(ctx, bson.M{"UserID": userID})
+}
+
+// RevokeByClient remove the authorization codes and the tokens issued to the client for all the users
+func (ts *TokenStore) RevokeByClient(ctx context.Context, clientID string) error {
+	return ts.revokeMatching(ctx, bson.M{"ClientID": clientID})
+}
+
+// revokeMatching revoke the token data matching the filter
+func (ts *TokenStore) revokeMatching(ctx context.Context, filter bson.M) error {
+	if err := ts.tenant(ctx); err != nil {
+		return err
+	}
+
+	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
+	defer cancel()
+	findCtx := ctx
+	if ctxReq != nil {
+		findCtx = withSpan(ctxReq, ctx)
+	}
+
+	cursor, err := ts.c(ctx, ts.tcfg.BasicCName).Find(findCtx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var basics []basicData
+	if err := cursor.All(findCtx, &basics); err != nil {
+		return err
+	}
+
+	for _, bd := range basics {
+		if err := ts.revokeBasic(ctx, bd.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeBasic remove the access and refresh tokens sharing basicID, then the token data
+// it goes through RemoveBy* so the middlewares, audit and revocation records apply
+func (ts *TokenStore) revokeBasic(ctx context.Context, basicID string) error {
+	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
+	defer cancel()
+	findCtx := ctx
+	if ctxReq != nil {
+		findCtx = withSpan(ctxReq, ctx)
+	}
+
+	for _, cname := range []string{ts.tcfg.AccessCName, ts.tcfg.RefreshCName} {
+		cursor, err := ts.c(ctx, cname).Find(findCtx, bson.M{"BasicID": basicID}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		var tokens []tokenData
+		if err := cursor.All(findCtx, &tokens); err != nil {
+			return err
+		}
+
+		for _, token := range tokens {
+			if cname == ts.tcfg.AccessCName {
+				err = ts.RemoveByAccess(ctx, token.ID)
+			} else {
+				err = ts.RemoveByRefresh(ctx, token.ID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := ts.c(ctx, ts.tcfg.BasicCName, ts.tcfg.storeConfig.writeOptions(WriteTokenRevoke)).DeleteOne(findCtx, bson.M{"_id": basicID})
+	return err
+}
+
+// lookup find the token information of the code, access or refresh token in the cname collection,
+// with the read options of the operation, then on the primary after a miss when the fallback is set
+func (ts *TokenStore) lookup(ctx context.Context, op ReadOperation, cname, token string) (ti oauth2.TokenInfo, err error) {
+	sc := ts.tcfg.storeConfig
+	ti, err = ts.find(ctx, cname, token, sc.readOptions(op, false))
+	if ti == nil && (err == nil || err == mongo.ErrNoDocuments) && sc.fallsBack(op) {
+		ti, err = ts.find(ctx, cname, token, sc.readOptions(op, true))
+	}
+	return
+}
+
+func (ts *TokenStore) find(ctx context.Context, cname, token string, opts *options.CollectionOptions) (oauth2.TokenInfo, error) {
+	if cname == ts.tcfg.BasicCName {
+		return ts.getData(ctx, token, opts)
+	}
+	basicID, err := ts.getBasicID(ctx, cname, token, opts)
+	if err != nil && basicID == "" {
+		return nil, err
+	}
+	return ts.getData(ctx, basicID, opts)
+}
+
+func (ts *TokenStore) getData(ctx context.Context, basicID string, opts ...*options.CollectionOptions) (ti oauth2.TokenInfo, err error) {
 	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
 	}
 
 	var bd basicData
-	err = ts.c(ts.tcfg.BasicCName).FindOne(ctx, bson.D{{Key: "_id", Value: basicID}}).Decode(&bd)
+	err = ts.c(ctx, ts.tcfg.BasicCName, opts...).FindOne(ctx, bson.D{{Key: "_id", Value: basicID}}).Decode(&bd)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -334,8 +637,17 @@ func (ts *TokenStore) getData(basicID string) (ti oauth2.TokenInfo, err error) {
 		return nil, err
 	}
 
+	data, err := ts.openData(ctx, &bd)
+	if err != nil {
+		return
+	}
+	// the tokens rotated from this refresh token keep its grant
+	if ce := codeExchangeFrom(ctx); ce != nil && bd.GrantID != "" && ts.tcfg.storeConfig.codeSingleUse {
+		ce.setGrant(bd.GrantID)
+	}
+
 	var tm models.Token
-	err = json.Unmarshal(bd.Data, &tm)
+	err = json.Unmarshal(data, &tm)
 	if err != nil {
 		return
 	}
@@ -343,16 +655,15 @@ func (ts *TokenStore) getData(basicID string) (ti oauth2.TokenInfo, err error) {
 	return
 }
 
-func (ts *TokenStore) getBasicID(cname, token string) (basicID string, err error) {
-	ctx := context.Background()
+func (ts *TokenStore) getBasicID(ctx context.Context, cname, token string, opts ...*options.CollectionOptions) (basicID string, err error) {
 	ctxReq, cancel := ts.tcfg.storeConfig.setRequestContext()
 	defer cancel()
 	if ctxReq != nil {
-		ctx = ctxReq
+		ctx = withSpan(ctxReq, ctx)
 	}
 
 	var td tokenData
-	err = ts.c(cname).FindOne(ctx, bson.D{{Key: "_id", Value: token}}).Decode(&td)
+	err = ts.c(ctx, cname, opts...).FindOne(ctx, bson.D{{Key: "_id", Value: token}}).Decode(&td)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return
@@ -364,35 +675,128 @@ func (ts *TokenStore) getBasicID(cname, token string) (basicID string, err error
 }
 
 // GetByCode use the authorization code for token information data
-func (ts *TokenStore) GetByCode(ctx context.Context, code string) (ti oauth2.TokenInfo, err error) {
-	ti, err = ts.getData(code)
+func (ts *TokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	call := &TokenCall{Operation: TokenGetByCode, Token: code}
+	err := ts.intercept(ctx, call, func(ctx context.Context, call *TokenCall) (err error) {
+		call.Info, err = ts.getByCode(ctx, call.Token)
+		return
+	})
+	return call.Info, err
+}
+
+func (ts *TokenStore) getByCode(ctx context.Context, code string) (ti oauth2.TokenInfo, err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.GetByCode", "find", ts.tcfg.BasicCName)
+	defer func() { op.endLookup(ti != nil, err) }()
+
+	if ts.tcfg.storeConfig.codeSingleUse {
+		ti, err = ts.consumeCode(ctx, code)
+		if err == ErrCodeReplayed {
+			// the caller sees an invalid code, the issued tokens are already revoked
+			ti, err = nil, nil
+		}
+		return
+	}
+
+	ti, err = ts.lookup(ctx, ReadGetByCode, ts.tcfg.BasicCName, code)
 	return
 }
 
 // GetByAccess use the access token for token information data
-func (ts *TokenStore) GetByAccess(ctx context.Context, access string) (ti oauth2.TokenInfo, err error) {
-	basicID, err := ts.getBasicID(ts.tcfg.AccessCName, access)
-	if err != nil && basicID == "" {
+func (ts *TokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	call := &TokenCall{Operation: TokenGetByAccess, Token: access}
+	err := ts.intercept(ctx, call, func(ctx context.Context, call *TokenCall) (err error) {
+		call.Info, err = ts.getByAccess(ctx, call.Token)
 		return
-	}
-	ti, err = ts.getData(basicID)
+	})
+	return call.Info, err
+}
+
+func (ts *TokenStore) getByAccess(ctx context.Context, access string) (ti oauth2.TokenInfo, err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.GetByAccess", "find", ts.tcfg.AccessCName)
+	defer func() { op.endLookup(ti != nil, err) }()
+
+	ti, err = ts.lookup(ctx, ReadGetByAccess, ts.tcfg.AccessCName, access)
 	return
 }
 
 // GetByRefresh use the refresh token for token information data
-func (ts *TokenStore) GetByRefresh(ctx context.Context, refresh string) (ti oauth2.TokenInfo, err error) {
-	basicID, err := ts.getBasicID(ts.tcfg.RefreshCName, refresh)
-	if err != nil && basicID == "" {
+func (ts *TokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	call := &TokenCall{Operation: TokenGetByRefresh, Token: refresh}
+	err := ts.intercept(ctx, call, func(ctx context.Context, call *TokenCall) (err error) {
+		call.Info, err = ts.getByRefresh(ctx, call.Token)
 		return
-	}
-	ti, err = ts.getData(basicID)
+	})
+	return call.Info, err
+}
+
+func (ts *TokenStore) getByRefresh(ctx context.Context, refresh string) (ti oauth2.TokenInfo, err error) {
+	ctx, op := ts.tcfg.storeConfig.startOperation(ctx, "TokenStore.GetByRefresh", "find", ts.tcfg.RefreshCName)
+	defer func() { op.endLookup(ti != nil, err) }()
+
+	ti, err = ts.lookup(ctx, ReadGetByRefresh, ts.tcfg.RefreshCName, refresh)
 	return
 }
 
+// Health verify the connectivity, the replicaSet primary, the TTL indexes
+// and, for a single node, the size of the transaction journal
+func (ts *TokenStore) Health(ctx context.Context) *HealthStatus {
+	hs := &HealthStatus{Status: HealthStatusUp}
+
+	err := checkConnectivity(ctx, ts.client)
+	hs.add("mongo.ping", err, nil)
+	if err != nil {
+		return hs
+	}
+
+	if ts.tcfg.storeConfig.isReplicaSet {
+		primary, err := checkPrimary(ctx, ts.client, ts.tcfg.storeConfig.db)
+		hs.add("mongo.primary", err, primary)
+	}
+
+	// a multi-tenant store checks the database of the tenant of ctx, if any
+	if ts.tcfg.storeConfig.checkTenant(ctx) != nil {
+		return hs
+	}
+
+	for _, cname := range []string{ts.tcfg.BasicCName, ts.tcfg.AccessCName, ts.tcfg.RefreshCName} {
+		err = checkIndex(ctx, ts.c(ctx, cname), "ExpiredAt", true, false)
+		hs.add("index."+cname, err, nil)
+	}
+
+	if !ts.tcfg.storeConfig.isReplicaSet {
+		limit := ts.tcfg.storeConfig.txnBacklogLimit
+		if limit <= 0 {
+			limit = defaultTxnBacklogLimit
+		}
+
+		backlog, err := ts.c(ctx, ts.tcfg.TxnCName).CountDocuments(ctx, bson.M{"Service": ts.tcfg.storeConfig.service})
+		if err == nil && backlog > limit {
+			err = fmt.Errorf("%d pending transactions exceed the limit of %d", backlog, limit)
+		}
+		hs.add("txn.backlog", err, backlog)
+	}
+
+	return hs
+}
+
 type basicData struct {
 	ID        string    `bson:"_id"`
 	Data      []byte    `bson:"Data"`
 	ExpiredAt time.Time `bson:"ExpiredAt"`
+	// the Data encrypted at rest, see SetFieldEncryption
+	Sealed *sealedField `bson:"Sealed,omitempty"`
+	// lookup by user and client, see RevokeByUserClient
+	UserID   string `bson:"UserID,omitempty"`
+	ClientID string `bson:"ClientID,omitempty"`
+	// single-use authorization codes, see ConsumeCode
+	ConsumedAt    *time.Time `bson:"ConsumedAt,omitempty"`
+	ReplayedAt    *time.Time `bson:"ReplayedAt,omitempty"`
+	IssuedBasicID string     `bson:"IssuedBasicID,omitempty"`
+	// the code the tokens descend from, through refresh rotations
+	GrantID string `bson:"GrantID,omitempty"`
+	// PKCE, see ConsumeCodeWithVerifier
+	CodeChallenge       string `bson:"CodeChallenge,omitempty"`
+	CodeChallengeMethod string `bson:"CodeChallengeMethod,omitempty"`
 }
 
 type tokenData struct {
@@ -127,6 +127,11 @@ func TestTokenStore(t *testing.T) {
 			store = NewTokenStore(NewConfigReplicaSet(url, dbName))
 		}
 
+		Convey("Test health", func() {
+			hs := store.Health(context.TODO())
+			So(hs.IsUp(), ShouldBeTrue)
+		})
+
 		Convey("Test authorization code store", func() {
 			info := &models.Token{
 				ClientID:      "1",
@@ -177,6 +182,32 @@ func TestTokenStore(t *testing.T) {
 			So(ainfo, ShouldBeNil)
 		})
 
+		Convey("Test revoke by refresh", func() {
+			info := &models.Token{
+				ClientID:         "1",
+				UserID:           "1_4",
+				RedirectURI:      "http://localhost/",
+				Scope:            "all",
+				Access:           "1_4_1",
+				AccessCreateAt:   time.Now(),
+				AccessExpiresIn:  time.Second * 5,
+				Refresh:          "1_4_2",
+				RefreshCreateAt:  time.Now(),
+				RefreshExpiresIn: time.Second * 15,
+			}
+			err := store.Create(context.TODO(), info)
+			So(err, ShouldBeNil)
+
+			err = store.RevokeByRefresh(context.TODO(), info.GetRefresh())
+			So(err, ShouldBeNil)
+
+			_, err = store.GetByAccess(context.TODO(), info.GetAccess())
+			So(err.Error(), ShouldEqual, "mongo: no documents in result")
+
+			_, err = store.GetByRefresh(context.TODO(), info.GetRefresh())
+			So(err.Error(), ShouldEqual, "mongo: no documents in result")
+		})
+
 		Convey("Test refresh token store", func() {
 			info := &models.Token{
 				ClientID:         "1",
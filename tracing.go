@@ -0,0 +1,92 @@
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName = "gopkg.in/go-oauth2/mongo.v3"
+
+	outcomeSuccess  = "success"
+	outcomeNotFound = "not_found"
+	outcomeError    = "error"
+)
+
+// SetTracerProvider set the OpenTelemetry tracer provider used to trace the stores operations
+// no span is recorded when it is not set
+func (sc *StoreConfig) SetTracerProvider(tp trace.TracerProvider) *StoreConfig {
+	sc.tracerProvider = tp
+	return sc
+}
+
+func (sc *StoreConfig) tracer() trace.Tracer {
+	if sc.tracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+	return sc.tracerProvider.Tracer(tracerName)
+}
+
+func (sc *StoreConfig) deploymentMode() string {
+	if sc.isSharded {
+		return "sharded"
+	}
+	if sc.isReplicaSet {
+		return "replicaset"
+	}
+	return "single"
+}
+
+// startSpan start a span for an operation on a collection
+// attributes must never contain token values
+func (sc *StoreConfig) startSpan(ctx context.Context, name, operation, collection string) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return sc.tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.name", sc.db),
+			attribute.String("db.mongodb.collection", collection),
+			attribute.String("db.operation", operation),
+			attribute.String("oauth2.mongo.deployment", sc.deploymentMode()),
+		),
+	)
+}
+
+// endSpan record the outcome of the operation and end the span
+func endSpan(span trace.Span, err error) {
+	switch {
+	case err == nil:
+		span.SetAttributes(attribute.String("oauth2.mongo.outcome", outcomeSuccess))
+	case err == mongo.ErrNoDocuments:
+		span.SetAttributes(attribute.String("oauth2.mongo.outcome", outcomeNotFound))
+	default:
+		span.SetAttributes(attribute.String("oauth2.mongo.outcome", outcomeError))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// withSpan carry the span, the tenant and the session of parent into ctx
+// request contexts are created from context.Background, so they have to be set again
+func withSpan(ctx, parent context.Context) context.Context {
+	ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(parent))
+	if tenant := TenantFromContext(parent); tenant != "" {
+		ctx = WithTenant(ctx, tenant)
+	}
+	if sess := mongo.SessionFromContext(parent); sess != nil {
+		ctx = mongo.NewSessionContext(ctx, sess)
+		if sess == causalSession(parent) {
+			ctx = context.WithValue(ctx, causalSessionKey{}, sess)
+		}
+	}
+	return ctx
+}
@@ -0,0 +1,50 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+	. "github.com/smartystreets/goconvey/convey"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTransactionTracing(t *testing.T) {
+	Convey("Test transaction spans", t, func() {
+		recorder := tracetest.NewSpanRecorder()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+		tcfg := NewDefaultTokenConfig(NewDefaultStoreConfig(dbName, service, false).SetTracerProvider(tp))
+		th := &transactionHandler{tcfg: tcfg, tw: &mockTransactionWorker{}}
+
+		info := &models.Token{
+			ClientID:         "1",
+			UserID:           "1_2",
+			Access:           "secret_access",
+			AccessCreateAt:   time.Now(),
+			AccessExpiresIn:  time.Second * 5,
+			Refresh:          "secret_refresh",
+			RefreshCreateAt:  time.Now(),
+			RefreshExpiresIn: time.Second * 15,
+		}
+		basicData := basicData{ID: "basic", Data: []byte("success"), ExpiredAt: time.Now()}
+		accessData := tokenData{ID: info.Access, BasicID: "basic", ExpiredAt: time.Now()}
+
+		err := th.runTransactionCreate(context.TODO(), info, basicData, accessData, "basic", time.Now())
+		So(err, ShouldBeNil)
+
+		spans := recorder.Ended()
+		names := []string{}
+		for _, span := range spans {
+			names = append(names, span.Name())
+			for _, attr := range span.Attributes() {
+				So(attr.Value.Emit(), ShouldNotContainSubstring, "secret")
+			}
+		}
+		So(names, ShouldResemble, []string{"transaction.T1", "transaction.T2", "transaction.T3", "transaction.cleanup"})
+
+		record = []string{}
+	})
+}
@@ -6,7 +6,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"log"
 	"time"
 )
 
@@ -32,17 +31,12 @@ func NewTransactionHandler(client *mongo.Client, tcfg *TokenConfig) *transaction
 	}
 }
 
-// runTransactionCreate run the transaction
+// runTransactionCreate run the transaction, ctx is bounded by the transaction timeout of TokenStore.Create
 func (th *transactionHandler) runTransactionCreate(ctx context.Context, info oauth2.TokenInfo, basicData basicData, accessData tokenData, id string, rexp time.Time) (errRET error) {
 
-	ctxReq, cancel := th.tcfg.storeConfig.setRequestContext()
-	defer cancel()
-	if ctxReq != nil {
-		ctx = ctxReq
-	}
-
 	// create id transaction idTXN
 	txnID := primitive.NewObjectID().Hex()
+	logger := th.tcfg.storeConfig.log()
 
 	// T1
 	basicTxnData := transactionData{
@@ -55,22 +49,29 @@ func (th *transactionHandler) runTransactionCreate(ctx context.Context, info oau
 
 	var err error
 
-	errRET = th.tw.insertBasicTransactionData(ctx, basicTxnData)
+	stepCtx, step := th.startStep(ctx, "T1", th.tcfg.BasicCName)
+	errRET = th.tw.insertBasicTransactionData(stepCtx, basicTxnData)
 	if errRET != nil {
-		log.Println("T1: Failed add basicData to TxnCName: ", errRET)
+		logger.Error("T1: Failed add basicData to TxnCName", "txn_id", txnID, "error", errRET)
+		step.end(errRET)
 		return
 	} else {
-		errRET = th.tw.insertBasicData(ctx, basicData)
+		errRET = th.tw.insertBasicData(stepCtx, basicData)
 		if errRET != nil {
-			log.Println("T1: Failed add basicData to BasicCName: ", errRET)
-			err = th.tw.removeTransactionData(ctx, basicData.ID)
+			logger.Error("T1: Failed add basicData to BasicCName", "txn_id", txnID, "error", errRET)
+			step.end(errRET)
+
+			rbCtx, rollback := th.startStep(ctx, "rollback", th.tcfg.TxnCName)
+			err = th.tw.removeTransactionData(rbCtx, basicData.ID)
 			if err != nil {
 				// basicTxnData from will be remove when service restart
-				log.Println("T2: Failed remove basicData from TxnCName: ", err)
+				logger.Error("T2: Failed remove basicData from TxnCName", "txn_id", txnID, "error", err)
 			}
+			rollback.end(err)
 			return
 		}
 	}
+	step.end(nil)
 
 	// T2
 	acccessTxnData := transactionData{
@@ -80,43 +81,54 @@ func (th *transactionHandler) runTransactionCreate(ctx context.Context, info oau
 		Service:    th.tcfg.storeConfig.service,
 		CreatedAt:  time.Now(),
 	}
-	errRET = th.tw.insertTokenTransactionData(ctx, acccessTxnData)
+	stepCtx, step = th.startStep(ctx, "T2", th.tcfg.AccessCName)
+	errRET = th.tw.insertTokenTransactionData(stepCtx, acccessTxnData)
 	if errRET != nil {
-		log.Println("T2: Failed insert accessData to TxnCName: ", errRET)
-		err = th.tw.removeBasicData(ctx, basicData.ID)
+		logger.Error("T2: Failed insert accessData to TxnCName", "txn_id", txnID, "error", errRET)
+		step.end(errRET)
+
+		rbCtx, rollback := th.startStep(ctx, "rollback", th.tcfg.BasicCName)
+		err = th.tw.removeBasicData(rbCtx, basicData.ID)
 		if err != nil {
 			// basicData will be remove when service restart
-			log.Println("T2: Failed remove basicData from BasicCName: ", err)
+			logger.Error("T2: Failed remove basicData from BasicCName", "txn_id", txnID, "error", err)
 		} else {
 			// basicData has been removed, then removed it in TxnCName
-			err = th.tw.removeTransactionData(ctx, basicData.ID)
+			err = th.tw.removeTransactionData(rbCtx, basicData.ID)
 			if err != nil {
-				log.Println("T2: Failed remove basicData from TxnCName: ", err)
+				logger.Error("T2: Failed remove basicData from TxnCName", "txn_id", txnID, "error", err)
 			}
 		}
+		rollback.end(err)
 		return
 	} else {
-		errRET = th.tw.insertTokenData(ctx, accessData, th.tcfg.AccessCName)
+		errRET = th.tw.insertTokenData(stepCtx, accessData, th.tcfg.AccessCName)
 		if errRET != nil {
-			log.Println("T2: Failed insert accessData to AccessCName: ", errRET)
-			err = th.tw.removeBasicData(ctx, basicData.ID)
-			if err != nil {
+			logger.Error("T2: Failed insert accessData to AccessCName", "txn_id", txnID, "error", errRET)
+			step.end(errRET)
+
+			rbCtx, rollback := th.startStep(ctx, "rollback", th.tcfg.BasicCName)
+			rbErr := th.tw.removeBasicData(rbCtx, basicData.ID)
+			if rbErr != nil {
 				// basicData from will be remove when service restart
-				log.Println("T2: Failed remove basicData from BasicCName: ", err)
+				logger.Error("T2: Failed remove basicData from BasicCName", "txn_id", txnID, "error", rbErr)
 			} else {
-				err = th.tw.removeTransactionData(ctx, basicData.ID)
-				if err != nil {
+				rbErr = th.tw.removeTransactionData(rbCtx, basicData.ID)
+				if rbErr != nil {
 					// basicTxnData from will be remove when service restart
-					log.Println("T2: Failed remove basicData from TxnCName: ", err)
+					logger.Error("T2: Failed remove basicData from TxnCName", "txn_id", txnID, "error", rbErr)
 				}
 			}
-			err = th.tw.removeTransactionData(ctx, accessData.ID)
+			err = th.tw.removeTransactionData(rbCtx, accessData.ID)
 			if err != nil {
-				log.Println("T2: Failed remove txnData from TxnCName: ", err)
+				logger.Error("T2: Failed remove txnData from TxnCName", "txn_id", txnID, "error", err)
+				rbErr = err
 			}
+			rollback.end(rbErr)
 			return
 		}
 	}
+	step.end(nil)
 
 	// T3
 	refresh := info.GetRefresh()
@@ -126,51 +138,66 @@ func (th *transactionHandler) runTransactionCreate(ctx context.Context, info oau
 			BasicID:   id,
 			ExpiredAt: rexp,
 		}
-		errRET = th.tw.insertTokenData(ctx, refreshData, th.tcfg.RefreshCName)
+		stepCtx, step = th.startStep(ctx, "T3", th.tcfg.RefreshCName)
+		errRET = th.tw.insertTokenData(stepCtx, refreshData, th.tcfg.RefreshCName)
+		step.end(errRET)
 		if errRET != nil {
-			log.Println("T3: Failed insert refreshData to RefreshCName: ", err)
-			err = th.tw.removeBasicData(ctx, basicData.ID)
-			if err != nil {
+			logger.Error("T3: Failed insert refreshData to RefreshCName", "txn_id", txnID, "error", errRET)
+
+			rbCtx, rollback := th.startStep(ctx, "rollback", th.tcfg.BasicCName)
+			rbErr := th.tw.removeBasicData(rbCtx, basicData.ID)
+			if rbErr != nil {
 				// basicData will be remove when service restart
-				log.Println("T3: Failed remove basicData from BasicCName: ", err)
+				logger.Error("T3: Failed remove basicData from BasicCName", "txn_id", txnID, "error", rbErr)
 			} else {
-				err = th.tw.removeTransactionData(ctx, basicData.ID)
-				if err != nil {
+				rbErr = th.tw.removeTransactionData(rbCtx, basicData.ID)
+				if rbErr != nil {
 					// basicData will be remove when service restart
-					log.Println("T3: Failed remove basicData from TxnCName: ", err)
+					logger.Error("T3: Failed remove basicData from TxnCName", "txn_id", txnID, "error", rbErr)
 				}
 			}
 
-			err = th.tw.removeTokenData(ctx, accessData.ID, th.tcfg.AccessCName)
+			err = th.tw.removeTokenData(rbCtx, accessData.ID, th.tcfg.AccessCName)
 			if err != nil {
 				// accessData will be remove when service restart
-				log.Println("T3: Failed remove accessData from AccessCName: ", err)
+				logger.Error("T3: Failed remove accessData from AccessCName", "txn_id", txnID, "error", err)
+				rbErr = err
 			} else {
-				err = th.tw.removeTransactionData(ctx, accessData.ID)
+				err = th.tw.removeTransactionData(rbCtx, accessData.ID)
 				if err != nil {
-					log.Println("T3: Failed remove txnData from TxnCName: ", err)
+					logger.Error("T3: Failed remove txnData from TxnCName", "txn_id", txnID, "error", err)
+					rbErr = err
 				}
 			}
+			rollback.end(rbErr)
 			return
 		}
 	}
 
 	// case all is fine, finally delete all txnDatas
-	err = th.tw.removeTransactionData(ctx, basicData.ID)
-	if err != nil {
+	cleanupCtx, cleanup := th.startStep(ctx, "cleanup", th.tcfg.TxnCName)
+	cleanupErr := th.tw.removeTransactionData(cleanupCtx, basicData.ID)
+	if cleanupErr != nil {
 		// basicTxnData will be remove when service restart
-		log.Println("EndTnx cleanup: Failed remove basicData from TxnCName: ", err)
+		logger.Error("EndTnx cleanup: Failed remove basicData from TxnCName", "txn_id", txnID, "error", cleanupErr)
 	}
 
-	err = th.tw.removeTransactionData(ctx, accessData.ID)
+	err = th.tw.removeTransactionData(cleanupCtx, accessData.ID)
 	if err != nil {
 		// accessTxnData will be remove when service restart
-		log.Println("EndTxn cleanup: Failed remove txnData from TxnCName: ", err)
+		logger.Error("EndTxn cleanup: Failed remove txnData from TxnCName", "txn_id", txnID, "error", err)
+		cleanupErr = err
 	}
+	cleanup.end(cleanupErr)
 
 	return nil
 }
 
+// startStep start the span and the timer of a transaction's step
+func (th *transactionHandler) startStep(ctx context.Context, step, collection string) (context.Context, *operation) {
+	return th.tcfg.storeConfig.startOperation(ctx, "transaction."+step, step, collection)
+}
+
 type TransactionWorker interface {
 	insertBasicData(ctx context.Context, basicData basicData) error
 	removeBasicData(ctx context.Context, basicDataID string) error
@@ -195,19 +222,20 @@ func NewTransactionWorker(t *TokenConfig, cl *mongo.Client) *transactionWorker {
 	}
 }
 
-func (tw *transactionWorker) getCollection(collName string) *mongo.Collection {
-	return tw.client.Database(tw.tc.storeConfig.db).Collection(collName)
+func (tw *transactionWorker) getCollection(ctx context.Context, collName string) *mongo.Collection {
+	return tw.client.Database(tw.tc.storeConfig.dbName(ctx)).Collection(collName, tw.tc.storeConfig.writeOptions(WriteTokenCreate))
 }
 
 func (tw *transactionWorker) insertBasicData(ctx context.Context, basicData basicData) error {
-	_, err := tw.getCollection(tw.tc.BasicCName).InsertOne(ctx, basicData)
+	_, err := tw.getCollection(ctx, tw.tc.BasicCName).InsertOne(ctx, basicData)
 	if err != nil {
 		if !mongo.IsDuplicateKeyError(err) {
-			log.Println("Err insertBasicData into BasicCname: ", err)
+			tw.tc.storeConfig.log().Error("Err insertBasicData into BasicCname", "operation", "insert", "collection", tw.tc.BasicCName, "error", err)
 		} else {
 			// in case of retry, the tuple may have already been inserted
 			// we like to carry on
-			log.Println("Err insertBasicData duplicated _id: ", err)
+			tw.tc.storeConfig.log().Warn("Err insertBasicData duplicated _id", "operation", "insert", "collection", tw.tc.BasicCName, "error", err)
+			tw.tc.storeConfig.metrics.duplicateKey(tw.tc.BasicCName)
 			return nil
 		}
 	}
@@ -215,21 +243,22 @@ func (tw *transactionWorker) insertBasicData(ctx context.Context, basicData basi
 }
 
 func (tw *transactionWorker) removeBasicData(ctx context.Context, basicDataID string) error {
-	_, err := tw.getCollection(tw.tc.BasicCName).DeleteOne(ctx, bson.D{{Key: "_id", Value: basicDataID}})
+	_, err := tw.getCollection(ctx, tw.tc.BasicCName).DeleteOne(ctx, bson.D{{Key: "_id", Value: basicDataID}})
 	if err != nil {
-		log.Println("Err removeBasicData from BasicCname: ", err)
+		tw.tc.storeConfig.log().Error("Err removeBasicData from BasicCname", "operation", "delete", "collection", tw.tc.BasicCName, "error", err)
 		return err
 	}
 	return err
 }
 
 func (tw *transactionWorker) insertBasicTransactionData(ctx context.Context, txnData transactionData) error {
-	_, err := tw.getCollection(tw.tc.TxnCName).InsertOne(ctx, txnData)
+	_, err := tw.getCollection(ctx, tw.tc.TxnCName).InsertOne(ctx, txnData)
 	if err != nil {
 		if !mongo.IsDuplicateKeyError(err) {
-			log.Println("Err insertBasicTransactionData to basicCname: ", err)
+			tw.tc.storeConfig.log().Error("Err insertBasicTransactionData to TxnCName", "operation", "insert", "collection", tw.tc.TxnCName, "error", err)
 		} else {
-			log.Println("Err insertBasicTransactionData duplicated _id: ", err)
+			tw.tc.storeConfig.log().Warn("Err insertBasicTransactionData duplicated _id", "operation", "insert", "collection", tw.tc.TxnCName, "error", err)
+			tw.tc.storeConfig.metrics.duplicateKey(tw.tc.TxnCName)
 			return nil
 		}
 	}
@@ -238,12 +267,13 @@ func (tw *transactionWorker) insertBasicTransactionData(ctx context.Context, txn
 
 // insertTokenData insert accessData and refreshData
 func (tw *transactionWorker) insertTokenData(ctx context.Context, tokenData tokenData, collectionName string) error {
-	_, err := tw.getCollection(collectionName).InsertOne(ctx, tokenData)
+	_, err := tw.getCollection(ctx, collectionName).InsertOne(ctx, tokenData)
 	if err != nil {
 		if !mongo.IsDuplicateKeyError(err) {
-			log.Printf("Err insertTokenData into %v: %v", collectionName, err)
+			tw.tc.storeConfig.log().Error("Err insertTokenData", "operation", "insert", "collection", collectionName, "error", err)
 		} else {
-			log.Println("Err insertTokenData duplicated _id: ", err)
+			tw.tc.storeConfig.log().Warn("Err insertTokenData duplicated _id", "operation", "insert", "collection", collectionName, "error", err)
+			tw.tc.storeConfig.metrics.duplicateKey(collectionName)
 			return nil
 		}
 	}
@@ -251,9 +281,9 @@ func (tw *transactionWorker) insertTokenData(ctx context.Context, tokenData toke
 }
 
 func (tw *transactionWorker) removeTokenData(ctx context.Context, tokenDataID, collectionName string) error {
-	_, err := tw.getCollection(collectionName).DeleteOne(ctx, bson.D{{Key: "_id", Value: tokenDataID}})
+	_, err := tw.getCollection(ctx, collectionName).DeleteOne(ctx, bson.D{{Key: "_id", Value: tokenDataID}})
 	if err != nil {
-		log.Printf("Err removeTransactionData from %v: %v", collectionName, err)
+		tw.tc.storeConfig.log().Error("Err removeTokenData", "operation", "delete", "collection", collectionName, "error", err)
 		return err
 	}
 	return err
@@ -261,12 +291,13 @@ func (tw *transactionWorker) removeTokenData(ctx context.Context, tokenDataID, c
 
 // insertTokenTransactionData insert accessData and refreshData to the TxnCName db
 func (tw *transactionWorker) insertTokenTransactionData(ctx context.Context, txnData transactionData) error {
-	_, err := tw.getCollection(tw.tc.TxnCName).InsertOne(ctx, txnData)
+	_, err := tw.getCollection(ctx, tw.tc.TxnCName).InsertOne(ctx, txnData)
 	if err != nil {
 		if !mongo.IsDuplicateKeyError(err) {
-			log.Println("Err insertTokenTransactionData into TxnCname: ", err)
+			tw.tc.storeConfig.log().Error("Err insertTokenTransactionData into TxnCname", "operation", "insert", "collection", tw.tc.TxnCName, "error", err)
 		} else {
-			log.Println("Err insertTokenTransactionData duplicated _id: ", err)
+			tw.tc.storeConfig.log().Warn("Err insertTokenTransactionData duplicated _id", "operation", "insert", "collection", tw.tc.TxnCName, "error", err)
+			tw.tc.storeConfig.metrics.duplicateKey(tw.tc.TxnCName)
 			return nil
 		}
 	}
@@ -275,9 +306,9 @@ func (tw *transactionWorker) insertTokenTransactionData(ctx context.Context, txn
 
 // removeTransactionData remove transaction's tuple
 func (tw *transactionWorker) removeTransactionData(ctx context.Context, tokenDataID string) error {
-	_, err := tw.getCollection(tw.tc.TxnCName).DeleteOne(ctx, bson.D{{Key: "_id", Value: tokenDataID}})
+	_, err := tw.getCollection(ctx, tw.tc.TxnCName).DeleteOne(ctx, bson.D{{Key: "_id", Value: tokenDataID}})
 	if err != nil {
-		log.Println("Err removeTransactionData from TxnCName: ", err)
+		tw.tc.storeConfig.log().Error("Err removeTransactionData from TxnCName", "operation", "delete", "collection", tw.tc.TxnCName, "error", err)
 		return err
 	}
 	return err
@@ -289,16 +320,19 @@ func (tw *transactionWorker) removeTransactionData(ctx context.Context, tokenDat
 * in this case clean the entries in the basicToken or/and accessToken then clean the TxnCName
 **/
 func (tw *transactionWorker) cleanupTransactionsData(ctx context.Context, service string) (err error) {
+	ctx, op := tw.tc.storeConfig.startOperation(ctx, "transaction.cleanupOrphans", "cleanup", tw.tc.TxnCName)
+	defer func() { op.end(err) }()
+
 	filter := bson.M{"Service": service}
-	cursor, err := tw.getCollection(tw.tc.TxnCName).Find(ctx, filter)
+	cursor, err := tw.getCollection(ctx, tw.tc.TxnCName).Find(ctx, filter)
 	if err != nil {
-		log.Println("Err cleanupTransactionsData findAll TxnCName: ", err)
+		tw.tc.storeConfig.log().Error("Err cleanupTransactionsData findAll TxnCName", "operation", "cleanup", "collection", tw.tc.TxnCName, "error", err)
 		return
 	}
 	// Iterate over the cursor to get all documents
 	var txnsData []transactionData
 	if err := cursor.All(ctx, &txnsData); err != nil {
-		log.Println("Err removeTransactionsData when iterate cursor: ", err)
+		tw.tc.storeConfig.log().Error("Err cleanupTransactionsData when iterate cursor", "operation", "cleanup", "collection", tw.tc.TxnCName, "error", err)
 	}
 
 	if len(txnsData) > 0 {
@@ -306,29 +340,33 @@ func (tw *transactionWorker) cleanupTransactionsData(ctx context.Context, servic
 			if txn.Collection == tw.tc.BasicCName {
 				err = tw.removeBasicData(ctx, txn.ID)
 				if err != nil {
-					log.Println("Err cleanupTransactionsData removeBasicData id: ", txn.ID)
+					tw.tc.storeConfig.log().Error("Err cleanupTransactionsData removeBasicData", "txn_id", txn.TxnID, "collection", txn.Collection, "error", err)
 					continue
 
 				}
 
+				tw.tc.storeConfig.metrics.orphanRollback()
+
 				err = tw.removeTransactionData(ctx, txn.ID)
 				if err != nil {
-					log.Println("Err cleanupTransactionsData removeTransactionData(basic) id: ", txn.ID)
+					tw.tc.storeConfig.log().Error("Err cleanupTransactionsData removeTransactionData(basic)", "txn_id", txn.TxnID, "collection", txn.Collection, "error", err)
 				}
 
 			} else if txn.Collection == tw.tc.AccessCName {
 				err = tw.removeTokenData(ctx, txn.ID, txn.Collection)
 				if err != nil {
-					log.Println("Err cleanupTransactionsData removeAccessData id: ", txn.ID)
+					tw.tc.storeConfig.log().Error("Err cleanupTransactionsData removeAccessData", "txn_id", txn.TxnID, "collection", txn.Collection, "error", err)
 					continue
 				}
 
+				tw.tc.storeConfig.metrics.orphanRollback()
+
 				err = tw.removeTransactionData(ctx, txn.ID)
 				if err != nil {
-					log.Println("Err cleanupTransactionsData removeTransactionData(access) id: ", txn.ID)
+					tw.tc.storeConfig.log().Error("Err cleanupTransactionsData removeTransactionData(access)", "txn_id", txn.TxnID, "collection", txn.Collection, "error", err)
 				}
 			} else {
-				log.Println("Err cleanupTransactionsData unfound collection: ", txn.Collection)
+				tw.tc.storeConfig.log().Error("Err cleanupTransactionsData unfound collection", "txn_id", txn.TxnID, "collection", txn.Collection)
 			}
 		}
 	}
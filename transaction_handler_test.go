@@ -8,6 +8,8 @@ import (
 
 	"github.com/go-oauth2/oauth2/v4/models"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // record the called methods
@@ -310,3 +312,48 @@ func (mt *mockTransactionWorker) cleanupTransactionsData(ctx context.Context, se
 	record = append(record, "cleanupTransactionsData")
 	return nil
 }
+
+// deadlineTracer record the deadline of the context of the spans
+type deadlineTracer struct {
+	noop.TracerProvider
+	deadlines map[string]time.Time
+}
+
+func (dt *deadlineTracer) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return &deadlineRecorder{deadlines: dt.deadlines}
+}
+
+type deadlineRecorder struct {
+	noop.Tracer
+	deadlines map[string]time.Time
+}
+
+func (dr *deadlineRecorder) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if deadline, ok := ctx.Deadline(); ok {
+		dr.deadlines[name] = deadline
+	}
+	return dr.Tracer.Start(ctx, name, opts...)
+}
+
+func TestCreateTransactionTimeout(t *testing.T) {
+	Convey("Test the transaction of Create runs with the transaction timeout", t, func() {
+		tracer := &deadlineTracer{deadlines: map[string]time.Time{}}
+		tcfg := NewDefaultTokenConfig(NewDefaultStoreConfig(dbName, service, false).SetTracerProvider(tracer))
+		tcfg.storeConfig.requestTimeout = 1
+		store := &TokenStore{tcfg: tcfg, txnHandler: &transactionHandler{tcfg: tcfg, tw: &mockTransactionWorker{}}}
+
+		info := &models.Token{
+			ClientID:        "1",
+			UserID:          "1_2",
+			Access:          "1_2_1",
+			AccessCreateAt:  time.Now(),
+			AccessExpiresIn: time.Second * 5,
+		}
+		So(store.Create(context.TODO(), info), ShouldBeNil)
+
+		// the request timeout is 1 second, the transaction runs up to 9 requests
+		So(tracer.deadlines["transaction.T1"], ShouldHappenAfter, time.Now().Add(2*time.Second))
+
+		record = []string{}
+	})
+}
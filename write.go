@@ -0,0 +1,51 @@
+package mongo
+
+import (
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// WriteOperation is a class of writes whose write concern can be configured
+type WriteOperation string
+
+const (
+	// WriteTokenCreate the codes and tokens creation, with the transaction journal of a single node
+	WriteTokenCreate WriteOperation = "TokenCreate"
+	// WriteTokenRevoke the codes consumption, the RemoveBy* calls and the revocation records
+	WriteTokenRevoke WriteOperation = "TokenRevoke"
+	// WriteClient the ClientStore writes
+	WriteClient WriteOperation = "Client"
+)
+
+// SetWriteConcern set the write concern(w, j, wtimeout) of the writes, all of them when no operation is given,
+// e.g. writeconcern.New(writeconcern.WMajority(), writeconcern.J(true)) for the revocations,
+// the other stores(device codes, consents...) apply the one set without operation
+func (sc *StoreConfig) SetWriteConcern(wc *writeconcern.WriteConcern, ops ...WriteOperation) *StoreConfig {
+	if sc.writeConcerns == nil {
+		sc.writeConcerns = map[WriteOperation]*writeconcern.WriteConcern{}
+	}
+	if len(ops) == 0 {
+		ops = []WriteOperation{""}
+	}
+	for _, op := range ops {
+		sc.writeConcerns[op] = wc
+	}
+	return sc
+}
+
+// writeConcern return the write concern of the operation, nil for the client default
+func (sc *StoreConfig) writeConcern(op WriteOperation) *writeconcern.WriteConcern {
+	if wc, ok := sc.writeConcerns[op]; ok {
+		return wc
+	}
+	return sc.writeConcerns[""]
+}
+
+// writeOptions return the collection options of the write, nil when nothing is configured
+func (sc *StoreConfig) writeOptions(op WriteOperation) *options.CollectionOptions {
+	wc := sc.writeConcern(op)
+	if wc == nil {
+		return nil
+	}
+	return options.Collection().SetWriteConcern(wc)
+}
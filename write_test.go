@@ -0,0 +1,30 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteOptions(t *testing.T) {
+	Convey("Test writes write concern", t, func() {
+		sc := NewStoreConfig(0, 0)
+		So(sc.writeOptions(WriteTokenRevoke), ShouldBeNil)
+
+		journaled := writeconcern.New(writeconcern.WMajority(), writeconcern.J(true), writeconcern.WTimeout(5*time.Second))
+		sc.SetWriteConcern(writeconcern.New(writeconcern.W(1))).
+			SetWriteConcern(journaled, WriteTokenRevoke)
+
+		So(sc.writeOptions(WriteTokenRevoke).WriteConcern, ShouldEqual, journaled)
+		So(sc.writeOptions(WriteTokenCreate).WriteConcern.GetW(), ShouldEqual, 1)
+		So(sc.writeOptions("").WriteConcern.GetW(), ShouldEqual, 1)
+
+		// the store configuration override keeps the write concerns
+		ts := NewDefaultStoreConfig(dbName, service, true)
+		ts.override(sc)
+		So(ts.writeConcern(WriteTokenRevoke), ShouldEqual, journaled)
+	})
+}